@@ -1,22 +1,44 @@
 package main
 
 import (
+	"context"
 	"cuturl/internal/app"
 	"cuturl/internal/auth"
 	"cuturl/internal/config"
+	"cuturl/internal/grpcapi"
+	"cuturl/internal/metrics"
 	"cuturl/internal/middleware"
 	"cuturl/internal/store"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	config.Init()
 	cfg := config.Get()
 
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(ctx, cfg, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.Println("Starting server on", cfg.RunAddress)
 
 	logger, err := zap.NewDevelopment()
@@ -26,52 +48,216 @@ func main() {
 	sugar := logger.Sugar()
 	defer logger.Sync()
 
-	var repo store.Repository
-	if cfg.DBConnection != "" {
-		db, err := store.NewPostgresRepository(cfg.DBConnection)
-		if err == nil {
-			repo = db
-			log.Println("Using PostgreSQL as storage")
-		} else {
-			log.Printf("Postgres connection failed: %v", err)
-		}
-	} else {
-		log.Println("No DB connection string set; falling back to file or memory storage")
+	repo, backend := openRepository(ctx, cfg, sugar)
+	if fr, ok := repo.(*store.FileRepository); ok {
+		go fr.RunCompactor(ctx, cfg.FileCompactInterval)
+	}
+	repo = metrics.InstrumentRepository(repo, backend)
+	if cfg.CacheSize > 0 && cfg.CacheTTL > 0 {
+		cached := store.NewCachedRepository(repo, cfg.CacheSize, cfg.CacheTTL)
+		metrics.RegisterCacheStats(cached.Stats)
+		repo = cached
 	}
 
-	if repo == nil && cfg.FileStoragePath != "" {
-		repo = store.NewFileRepository(cfg.FileStoragePath)
-		log.Println("Using FileStorage as storage")
+	keyring, err := auth.LoadKeyring(cfg.AuthKeys, cfg.AuthSecret, cfg.AuthSecretFile)
+	if err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+	sameSite, err := auth.ParseSameSite(cfg.AuthCookieSameSite)
+	if err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+	cookie := auth.CookieOptions{
+		Name:       cfg.AuthCookieName,
+		Domain:     cfg.AuthCookieDomain,
+		Secure:     cfg.AuthCookieSecure,
+		SameSite:   sameSite,
+		HostPrefix: cfg.AuthCookieHostPrefix,
+	}
+	if err := cookie.Validate(); err != nil {
+		log.Fatalf("refusing to start: %v", err)
 	}
+	auth.Init(keyring, auth.Options{
+		TTL:               cfg.AuthTokenTTL,
+		RenewAfter:        cfg.AuthTokenRenewAfter,
+		Cookie:            cookie,
+		LegacyTokensUntil: cfg.AuthLegacyTokensUntil,
+	})
+
+	u := app.NewURLShortener(sugar, repo)
+	go u.Service().RunExpirySweeper(ctx, cfg.ExpirySweepInterval)
+	go u.Service().RunSafetyReloader(ctx, cfg.SafetyReloadInterval)
+	metrics.RegisterDeleteQueueDepth(u.Service().DeleteQueueDepth)
+
+	root := chi.NewRouter()
+	root.Use(middleware.LoggingMiddleware(sugar))
+	root.Use(middleware.MetricsMiddleware)
+	root.Use(middleware.GzipCompressMiddleware)
+	root.Use(middleware.GzipDecompressMiddleware)
 
-	if repo == nil {
-		repo = store.NewInMemoryRepository()
-		log.Println("Using Memory as storage")
+	// Scrapers carry no cookie and no Origin: /metrics is left out of auth
+	// and CSRF, and can be moved off the public listener altogether.
+	if cfg.MetricsAddress == "" {
+		root.Handle("/metrics", metrics.Handler())
 	}
 
-	auth.Init(cfg.AuthSecret)
+	r := root.With(
+		middleware.NewAuthMiddleware(u.Service()),
+		middleware.CSRFMiddleware(append([]string{cfg.BaseURL}, cfg.CSRFTrustedOrigins...)),
+	)
 
-	u := app.NewURLShortener(sugar, repo)
-	r := chi.NewRouter()
-	r.Use(middleware.LoggingMiddleware(sugar))
-	r.Use(middleware.GzipCompressMiddleware)
-	r.Use(middleware.GzipDecompressMiddleware)
-	r.Use(middleware.AuthMiddleware)
-	r.Post("/", http.HandlerFunc(u.OrigURLHandler))
-	r.Get("/{id}", http.HandlerFunc(u.ShortURLHandler))
+	limits := middleware.NewMemoryRateLimitStore()
+	proxies := middleware.TrustedProxies(cfg.TrustedProxies)
+	limitCreate := middleware.RateLimitMiddleware(limits, "create", rateLimit(cfg.CreateRateLimit), proxies, sugar)
+	limitRedirect := middleware.RateLimitMiddleware(limits, "redirect", rateLimit(cfg.RedirectRateLimit), proxies, sugar)
+	limitDelete := middleware.RateLimitMiddleware(limits, "delete", rateLimit(cfg.DeleteRateLimit), proxies, sugar)
+
+	r.With(limitCreate).Post("/", http.HandlerFunc(u.OrigURLHandler))
+	r.With(limitRedirect).Get("/{id}", http.HandlerFunc(u.ShortURLHandler))
 	r.Get("/ping", http.HandlerFunc(u.PingHandler))
 
 	r.Route("/api/shorten", func(r chi.Router) {
+		r.Use(limitCreate)
 		r.Post("/", http.HandlerFunc(u.OrigURLJSONHandler))
 		r.Post("/batch", http.HandlerFunc(u.ShortenBatchHandler))
 	})
 
 	r.Route("/api/user", func(r chi.Router) {
 		r.Get("/urls", http.HandlerFunc(u.UserURLsHandler))
-		r.Delete("/urls", http.HandlerFunc(u.DeleteUserURLSHandler))
+		r.With(limitDelete).Delete("/urls", http.HandlerFunc(u.DeleteUserURLSHandler))
+		r.Get("/urls/{id}/stats", http.HandlerFunc(u.UserURLStatsHandler))
+		r.Post("/keys", http.HandlerFunc(u.CreateAPIKeyHandler))
+		r.Get("/keys", http.HandlerFunc(u.ListAPIKeysHandler))
+		r.Delete("/keys/{id}", http.HandlerFunc(u.RevokeAPIKeyHandler))
 	})
 
-	if err := http.ListenAndServe(cfg.RunAddress, r); err != nil {
-		log.Fatalf("server failed to start: %v", err)
+	srv := &http.Server{Addr: cfg.RunAddress, Handler: root}
+	serveErr := make(chan error, 3)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var metricsSrv *http.Server
+	if cfg.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsSrv = &http.Server{Addr: cfg.MetricsAddress, Handler: metricsMux}
+		log.Println("Serving metrics on", cfg.MetricsAddress)
+		go func() {
+			serveErr <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	var grpcSrv *grpc.Server
+	if cfg.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatalf("grpc listen failed: %v", err)
+		}
+		grpcSrv = grpcapi.NewServer(u.Service(), sugar, cfg.BaseURL)
+		log.Println("Starting gRPC server on", cfg.GRPCAddress)
+		go func() {
+			serveErr <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server failed to start: %v", err)
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down server")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("metrics server shutdown: %v", err)
+		}
+	}
+	if grpcSrv != nil {
+		stopGRPC(shutdownCtx, grpcSrv)
+	}
+
+	if err := u.Service().Shutdown(shutdownCtx); err != nil {
+		log.Printf("pending deletions or clicks abandoned: %v", err)
+	}
+
+	if closer, ok := repo.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("failed to close storage: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// openRepository picks the storage backend from cfg: the configured database,
+// else the storage file, else memory. A database that cannot be opened falls
+// back to the next option.
+func openRepository(ctx context.Context, cfg *config.Config, logger *zap.SugaredLogger) (store.Repository, string) {
+	dedup := store.WithDedupScope(store.DedupScope(cfg.DedupScope))
+	switch cfg.DBDriver {
+	case "sqlite":
+		db, err := store.NewSQLiteRepository(ctx, cfg.DBConnection, dedup)
+		if err == nil {
+			log.Println("Using SQLite as storage")
+			return db, "sqlite"
+		}
+		log.Printf("SQLite open failed: %v", err)
+	case "postgres":
+		db, err := store.NewPostgresRepository(ctx, cfg.DBConnection, dedup)
+		if err == nil {
+			log.Println("Using PostgreSQL as storage")
+			return db, "postgres"
+		}
+		log.Printf("Postgres connection failed: %v", err)
+	default:
+		log.Println("No DB connection string set; falling back to file or memory storage")
+	}
+
+	if cfg.FileStoragePath != "" {
+		log.Println("Using FileStorage as storage")
+		return store.NewFileRepository(cfg.FileStoragePath, dedup, store.WithLogger(logger)), "file"
+	}
+	log.Println("Using Memory as storage")
+	return store.NewInMemoryRepository(dedup), "memory"
+}
+
+func runCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, cfg, args[1:])
+	case "export":
+		return runExport(ctx, cfg, args[1:])
+	case "import":
+		return runImport(ctx, cfg, args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// rateLimit turns "Requests per Per" into a token bucket that can absorb the
+// whole allowance at once.
+func rateLimit(l config.RateLimit) middleware.RateLimit {
+	if l.Requests == 0 {
+		return middleware.RateLimit{}
 	}
+	return middleware.RateLimit{Rate: float64(l.Requests) / l.Per.Seconds(), Burst: l.Requests}
 }
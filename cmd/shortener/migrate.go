@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"cuturl/internal/config"
+	"cuturl/internal/store"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const migrateUsage = "usage: shortener [flags] migrate up | down [steps] | status"
+
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	if cfg.DBConnection == "" {
+		return errors.New("migrate needs a database connection string (-d or DATABASE_DSN)")
+	}
+
+	open := store.OpenPostgresMigrator
+	if cfg.DBDriver == "sqlite" {
+		open = store.OpenSQLiteMigrator
+	}
+	m, err := open(ctx, cfg.DBConnection)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		fmt.Printf("applied %d migration(s)\n", n)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		fmt.Printf("reverted %d migration(s)\n", n)
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
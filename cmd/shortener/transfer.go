@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"cuturl/internal/config"
+	"cuturl/internal/store"
+	"cuturl/internal/transfer"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	exportUsage = "usage: shortener [flags] export [-format jsonl|csv] [-o file] [-resume]"
+	importUsage = "usage: shortener [flags] import [-format jsonl|csv] [-batch-size n] [-dry-run] [-resume] file"
+)
+
+// openTransferRepository opens the configured backend for export or import.
+// Unlike the server it does not fall back: copying into the wrong store, or
+// out of an empty in-memory one, is never what the operator wanted.
+func openTransferRepository(ctx context.Context, cfg *config.Config) (store.Repository, error) {
+	if cfg.DBConnection == "" && cfg.FileStoragePath == "" {
+		return nil, errors.New("no storage configured: set a database (-d or DATABASE_DSN) or a storage file (-f or FILE_STORAGE_PATH)")
+	}
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		return nil, fmt.Errorf("create logger: %w", err)
+	}
+	repo, backend := openRepository(ctx, cfg, logger.Sugar())
+	if cfg.DBConnection != "" && backend != cfg.DBDriver {
+		closeRepository(repo)
+		return nil, fmt.Errorf("cannot open %s storage", cfg.DBDriver)
+	}
+	return repo, nil
+}
+
+func closeRepository(repo store.Repository) {
+	if closer, ok := repo.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("failed to close storage: %v", err)
+		}
+	}
+}
+
+func runExport(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := fs.String("format", "", "jsonl or csv (default: from the file extension, else jsonl)")
+	out := fs.String("o", "-", "output file, - for stdout")
+	resume := fs.Bool("resume", false, "append to an interrupted export instead of starting over")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errors.New(exportUsage)
+	}
+	format, err := transfer.ParseFormat(*formatName, *out)
+	if err != nil {
+		return err
+	}
+	if *resume && *out == "-" {
+		return errors.New("-resume needs an output file")
+	}
+
+	repo, err := openTransferRepository(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepository(repo)
+
+	w := os.Stdout
+	after := ""
+	header := true
+	if *out != "-" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *resume {
+			flags = os.O_CREATE | os.O_RDWR
+		}
+		f, err := os.OpenFile(*out, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if *resume {
+			if after, header, err = resumeExport(f, format); err != nil {
+				return fmt.Errorf("resume %s: %w", *out, err)
+			}
+		}
+		w = f
+	}
+
+	n, err := transfer.Export(ctx, repo, transfer.NewEncoder(w, format, header), after)
+	if err != nil {
+		return err
+	}
+	if w != os.Stdout {
+		if err := w.Sync(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d link(s)\n", n)
+	return nil
+}
+
+// resumeExport drops a partially written last line from f, positions f at
+// the end and returns the short ID of the last complete record, and whether
+// the file still needs a header.
+func resumeExport(f *os.File, format transfer.Format) (after string, header bool, err error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", false, err
+	}
+	complete := data[:bytes.LastIndexByte(data, '\n')+1]
+	if err := f.Truncate(int64(len(complete))); err != nil {
+		return "", false, err
+	}
+	if _, err := f.Seek(int64(len(complete)), io.SeekStart); err != nil {
+		return "", false, err
+	}
+	after, err = transfer.LastShortID(transfer.NewDecoder(bytes.NewReader(complete), format))
+	return after, len(complete) == 0, err
+}
+
+func runImport(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatName := fs.String("format", "", "jsonl or csv (default: from the file extension, else jsonl)")
+	batchSize := fs.Int("batch-size", transfer.DefaultBatchSize, "records written per batch")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing anything")
+	resume := fs.Bool("resume", false, "skip the records an interrupted import already processed")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || *batchSize <= 0 {
+		return errors.New(importUsage)
+	}
+	path := fs.Arg(0)
+	format, err := transfer.ParseFormat(*formatName, path)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	} else if *resume {
+		return errors.New("-resume needs an input file")
+	}
+
+	// The checkpoint holds the number of records already processed. Imports
+	// are idempotent, so it only saves re-reading; a stale one is harmless.
+	progressPath := path + ".progress"
+	opts := transfer.ImportOptions{
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+		OnConflict: func(u store.StoredURL, reason string) {
+			fmt.Fprintf(os.Stderr, "conflict: %s -> %s: %s\n", u.ShortURL, u.OriginalURL, reason)
+		},
+	}
+	if path != "-" {
+		opts.Checkpoint = func(processed int) error {
+			return os.WriteFile(progressPath, []byte(strconv.Itoa(processed)+"\n"), 0o644)
+		}
+	}
+	if *resume {
+		if opts.Skip, err = readProgress(progressPath); err != nil {
+			return err
+		}
+	}
+
+	repo, err := openTransferRepository(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepository(repo)
+
+	stats, err := transfer.Import(ctx, repo, transfer.NewDecoder(r, format), opts)
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(os.Stderr, "processed %d record(s): %s %d, %d already present, %d conflict(s)\n",
+		stats.Processed, verb, stats.Created, stats.Existing, stats.Conflicts)
+	if err != nil {
+		return err
+	}
+	if opts.Checkpoint != nil && !*dryRun {
+		if err := os.Remove(progressPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readProgress(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("corrupt checkpoint %s", path)
+	}
+	return n, nil
+}
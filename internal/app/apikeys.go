@@ -0,0 +1,142 @@
+package app
+
+import (
+	"cuturl/internal/middleware"
+	"cuturl/internal/service"
+	"cuturl/internal/store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeyResponse describes a key without its secret, except in the response
+// to the request that created it.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyResponse(key store.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+func (u *URLShortener) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(middleware.UserIDKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := service.ResolveExpiry(req.TTLSeconds, req.ExpiresAt, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, key, err := u.service.CreateAPIKey(ctx, userID, req.Name, expiresAt)
+	switch {
+	case errors.Is(err, service.ErrInvalidKeyName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrAPIKeysUnsupported):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	case err != nil:
+		u.logger.Errorf("failed to create API key: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp := newAPIKeyResponse(*key)
+	resp.Key = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		u.logger.Errorw("failed to encode response", "error", err)
+	}
+}
+
+func (u *URLShortener) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(middleware.UserIDKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := u.service.ListAPIKeys(ctx, userID)
+	switch {
+	case errors.Is(err, service.ErrAPIKeysUnsupported):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	case err != nil:
+		u.logger.Errorf("failed to list API keys: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, newAPIKeyResponse(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		u.logger.Errorw("failed to encode response", "error", err)
+	}
+}
+
+func (u *URLShortener) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(middleware.UserIDKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	err := u.service.RevokeAPIKey(ctx, userID, chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrAPIKeysUnsupported):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	case err != nil:
+		u.logger.Errorf("failed to revoke API key: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"cuturl/internal/auth"
+	"cuturl/internal/config"
+	"cuturl/internal/middleware"
+	"cuturl/internal/store"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAPIKeyHandlers(t *testing.T) {
+	config.Init()
+	ring, err := auth.NewKeyring(auth.Key{ID: "test", Secret: []byte("test-secret")})
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+	u := NewURLShortener(zap.NewNop().Sugar(), store.NewInMemoryRepository())
+	defer u.service.Close()
+
+	r := chi.NewRouter()
+	r.Use(middleware.NewAuthMiddleware(u.Service()))
+	r.Post("/api/shorten", http.HandlerFunc(u.OrigURLJSONHandler))
+	r.Get("/api/user/urls", http.HandlerFunc(u.UserURLsHandler))
+	r.Post("/api/user/keys", http.HandlerFunc(u.CreateAPIKeyHandler))
+	r.Get("/api/user/keys", http.HandlerFunc(u.ListAPIKeysHandler))
+	r.Delete("/api/user/keys/{id}", http.HandlerFunc(u.RevokeAPIKeyHandler))
+
+	do := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A browser session creates a key...
+	cookie := http.Header{"Cookie": {"auth_token=" + auth.IssueToken("user-1")}}
+	w := do(http.MethodPost, "/api/user/keys", `{"name":"ci","ttl_seconds":3600}`, cookie)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "ci", created.Name)
+	assert.NotEmpty(t, created.Key)
+	assert.NotNil(t, created.ExpiresAt)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/keys", `{"name":""}`, cookie).Code)
+
+	// ...that acts as the same user.
+	bearer := http.Header{"Authorization": {"Bearer " + created.Key}}
+	w = do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/ci"}`, bearer)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Result().Cookies(), "key requests don't get a cookie")
+	w = do(http.MethodGet, "/api/user/urls", "", cookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com/ci")
+
+	w = do(http.MethodGet, "/api/user/keys", "", bearer)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Key)
+	assert.NotNil(t, listed[0].LastUsedAt)
+
+	other := http.Header{"Cookie": {"auth_token=" + auth.IssueToken("user-2")}}
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/keys", "", other).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", other).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", cookie).Code)
+	w = do(http.MethodGet, "/api/user/urls", "", bearer)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/urls", "", http.Header{"Authorization": {"Bearer nonsense"}}).Code)
+}
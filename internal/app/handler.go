@@ -1,14 +1,14 @@
 package app
 
 import (
-	"crypto/rand"
 	"cuturl/internal/config"
+	"cuturl/internal/metrics"
 	"cuturl/internal/middleware"
 	"cuturl/internal/store"
 	"io"
-	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
 	"encoding/json"
 
@@ -25,73 +25,148 @@ import (
 )
 
 type URLShortener struct {
-	letters []rune
 	logger  *zap.SugaredLogger
 	repo    store.Repository
 	service *service.URLService
+	// proxies lets clicks be attributed to the client behind a trusted proxy.
+	proxies middleware.TrustedProxies
 }
 
 type Request struct {
-	URL string `json:"url"`
+	URL        string     `json:"url"`
+	Alias      string     `json:"alias,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
 }
 type Response struct {
 	Result string `json:"result"`
 }
 type BatchRequestItem struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 }
 
 type BatchResponseItem struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	// Code is the service.URLError code when the original URL was rejected.
+	Code string `json:"code,omitempty"`
+}
+
+// RejectedURLResponse is the body every shorten endpoint sends for an original
+// URL that fails validation (400, error "invalid_url") or is blocked by a
+// safety checker (422, error "unsafe_url").
+type RejectedURLResponse struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func NewURLShortener(logger *zap.SugaredLogger, repo store.Repository) *URLShortener {
+	cfg := config.Get()
 	us := &URLShortener{
-		letters: []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"),
 		logger:  logger,
 		repo:    repo,
-		service: service.NewURLService(repo, logger),
+		proxies: middleware.TrustedProxies(cfg.TrustedProxies),
+		service: service.NewURLService(repo, logger, service.Options{
+			DeleteQueueSize:     cfg.DeleteQueueSize,
+			DeleteBatchSize:     cfg.DeleteBatchSize,
+			DeleteFlushInterval: cfg.DeleteFlushInterval,
+			IDStrategy:          cfg.IDStrategy,
+			IDAlphabet:          cfg.IDAlphabet,
+			IDLength:            cfg.IDLength,
+			IDRangeSize:         cfg.IDRangeSize,
+			URLPolicy: service.URLPolicy{
+				AllowedSchemes:      cfg.AllowedURLSchemes,
+				StripTrackingParams: cfg.StripTrackingParams,
+			},
+			SafetyCheckers:  safetyCheckers(logger, cfg),
+			BaseURL:         cfg.BaseURL,
+			ShortenerHosts:  cfg.ShortenerHosts,
+			ShortLinkPolicy: cfg.ShortLinkPolicy,
+		}),
 	}
 	return us
 }
 
-func (u *URLShortener) generateShorten(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(u.letters))))
+// safetyCheckers loads the configured blocklist and hash prefix database. A
+// file that can't be loaded at startup is fatal, since running without it
+// would let through links it is meant to stop.
+func safetyCheckers(logger *zap.SugaredLogger, cfg *config.Config) []service.SafetyChecker {
+	var checkers []service.SafetyChecker
+	if cfg.SafetyBlocklist != "" {
+		b, err := service.NewBlocklistChecker(cfg.SafetyBlocklist)
+		if err != nil {
+			logger.Fatalw("failed to load safety blocklist", "error", err)
+		}
+		checkers = append(checkers, b)
+	}
+	if cfg.SafetyHashPrefixes != "" {
+		h, err := service.NewHashPrefixChecker(cfg.SafetyHashPrefixes)
 		if err != nil {
-			panic(err)
+			logger.Fatalw("failed to load hash prefix database", "error", err)
 		}
-		b[i] = u.letters[num.Int64()]
+		checkers = append(checkers, h)
 	}
-	return string(b)
+	return checkers
+}
+
+func (u *URLShortener) Service() *service.URLService {
+	return u.service
 }
 
-func (u *URLShortener) getOrCreateShortURL(ctx context.Context, originalURL string, userID string) (string, int, error) {
-	shortID := u.generateShorten(8)
-	entry := store.StoredURL{
-		UUID:        shortID,
-		ShortURL:    shortID,
+func (u *URLShortener) getOrCreateShortURL(ctx context.Context, originalURL string, alias string, userID string, expiresAt *time.Time) (string, int, error) {
+	entry, created, err := u.service.Shorten(ctx, service.ShortenInput{
 		OriginalURL: originalURL,
+		Alias:       alias,
 		UserID:      userID,
+		ExpiresAt:   expiresAt,
+	})
+	switch {
+	case err == nil && created:
+		return entry.ShortURL, http.StatusCreated, nil
+	case err == nil:
+		return entry.ShortURL, http.StatusConflict, nil
+	case errors.Is(err, service.ErrInvalidAlias) || errors.Is(err, service.ErrReservedAlias),
+		errors.Is(err, service.ErrInvalidURL):
+		return "", http.StatusBadRequest, err
+	case errors.Is(err, service.ErrUnsafeURL):
+		return "", http.StatusUnprocessableEntity, err
+	case errors.Is(err, service.ErrAliasTaken):
+		return "", http.StatusConflict, err
 	}
 
-	err := u.service.SaveURL(ctx, entry)
-	if err == nil {
-		return shortID, http.StatusCreated, nil
-	}
+	return "", http.StatusInternalServerError, err
+}
 
-	if errors.Is(err, store.ErrUniqueViolation) {
-		existing, findErr := u.service.GetByOriginalURL(ctx, originalURL)
-		if findErr != nil || existing == nil {
-			return "", http.StatusInternalServerError, findErr
-		}
-		return existing.ShortURL, http.StatusConflict, nil
+// writeRejectedURL sends the structured response for a rejected original URL
+// and reports whether err was one.
+func writeRejectedURL(w http.ResponseWriter, err error) bool {
+	var (
+		urlErr    *service.URLError
+		unsafeErr *service.UnsafeURLError
+		status    int
+		body      RejectedURLResponse
+	)
+	switch {
+	case errors.As(err, &urlErr):
+		status = http.StatusBadRequest
+		body = RejectedURLResponse{Error: "invalid_url", Code: urlErr.Code, Message: urlErr.Message}
+	case errors.As(err, &unsafeErr):
+		status = http.StatusUnprocessableEntity
+		body = RejectedURLResponse{Error: "unsafe_url", Code: "blocked", Message: unsafeErr.Reason}
+	default:
+		return false
 	}
-
-	return "", http.StatusInternalServerError, err
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+	return true
 }
 
 func (u *URLShortener) OrigURLHandler(res http.ResponseWriter, req *http.Request) {
@@ -112,8 +187,11 @@ func (u *URLShortener) OrigURLHandler(res http.ResponseWriter, req *http.Request
 	}
 	userID, _ := ctx.Value(middleware.UserIDKey).(string)
 
-	shortID, status, err := u.getOrCreateShortURL(ctx, origURL, userID)
+	shortID, status, err := u.getOrCreateShortURL(ctx, origURL, "", userID, nil)
 	if err != nil {
+		if writeRejectedURL(res, err) {
+			return
+		}
 		if status == http.StatusConflict {
 			u.logger.Errorf("failed to handle URL: URL exists %q: %v", origURL, err)
 			http.Error(res, "", http.StatusConflict)
@@ -139,17 +217,57 @@ func (u *URLShortener) ShortURLHandler(res http.ResponseWriter, req *http.Reques
 	}
 
 	entry, err := u.service.GetByShortID(ctx, id)
-	if err != nil {
-		u.logger.Errorf("failed to find short ID: %v", err)
+	if errors.Is(err, store.ErrNotFound) {
+		metrics.Redirects.WithLabelValues("miss").Inc()
 		http.Error(res, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		u.logger.Errorf("failed to find short ID %q: %v", id, err)
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
-	if entry.IsDeleted {
+	if entry.IsDeleted || entry.IsExpired(time.Now()) {
+		metrics.Redirects.WithLabelValues("gone").Inc()
 		res.WriteHeader(http.StatusGone)
 		return
 	}
 
+	// Links on this shortener that point at each other must not bounce
+	// clients around forever.
+	err = u.service.CheckRedirectLoop(ctx, entry)
+	if errors.Is(err, service.ErrRedirectLoop) {
+		metrics.Redirects.WithLabelValues("loop").Inc()
+		http.Error(res, err.Error(), http.StatusLoopDetected)
+		return
+	}
+	if err != nil {
+		u.logger.Errorf("redirect loop check for %q failed: %v", id, err)
+	}
+
+	// Links created before their target was blocked get a warning page
+	// instead of a redirect. A failing checker doesn't stop redirects.
+	verdict, err := u.service.CheckSafety(ctx, entry.OriginalURL)
+	if err != nil {
+		u.logger.Errorf("safety check for %q failed: %v", entry.OriginalURL, err)
+	}
+	if verdict.Blocked {
+		metrics.Redirects.WithLabelValues("blocked").Inc()
+		writeInterstitial(res, entry.OriginalURL, verdict.Reason)
+		return
+	}
+
+	metrics.Redirects.WithLabelValues("hit").Inc()
+
+	u.service.RecordClick(store.ClickEvent{
+		ShortURL:  entry.ShortURL,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPBucket:  service.IPBucket(u.proxies.ClientIP(req)),
+	})
+
 	res.Header().Set("Location", entry.OriginalURL)
 	res.WriteHeader(http.StatusTemporaryRedirect)
 }
@@ -168,14 +286,21 @@ func (u *URLShortener) OrigURLJSONHandler(res http.ResponseWriter, req *http.Req
 		http.Error(res, "Empty URL", http.StatusBadRequest)
 		return
 	}
+	expiresAt, err := service.ResolveExpiry(reqBody.TTLSeconds, reqBody.ExpiresAt, time.Now())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
 	userID, _ := ctx.Value(middleware.UserIDKey).(string)
 
-	shortID, status, err := u.getOrCreateShortURL(ctx, reqBody.URL, userID)
+	shortID, status, err := u.getOrCreateShortURL(ctx, reqBody.URL, reqBody.Alias, userID, expiresAt)
 	if err != nil {
-		if status == http.StatusConflict {
-			res.Header().Set("Content-Type", "application/json")
-			u.logger.Errorf("failed to handle URL: URL exists %q: %v", reqBody.URL, err)
-			http.Error(res, "", http.StatusConflict)
+		if writeRejectedURL(res, err) {
+			return
+		}
+		if status == http.StatusBadRequest || status == http.StatusConflict {
+			u.logger.Errorf("failed to handle URL %q with alias %q: %v", reqBody.URL, reqBody.Alias, err)
+			http.Error(res, err.Error(), status)
 			return
 		}
 		u.logger.Errorf("failed to save or find url: %v", err)
@@ -194,7 +319,7 @@ func (u *URLShortener) OrigURLJSONHandler(res http.ResponseWriter, req *http.Req
 }
 
 func (u *URLShortener) PingHandler(w http.ResponseWriter, r *http.Request) {
-	if err := u.repo.Ping(); err != nil {
+	if err := u.repo.Ping(r.Context()); err != nil {
 		u.logger.Errorw("DB ping failed", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -213,40 +338,75 @@ func (u *URLShortener) ShortenBatchHandler(w http.ResponseWriter, r *http.Reques
 
 	userID, _ := ctx.Value(middleware.UserIDKey).(string)
 
-	result := make([]BatchResponseItem, 0, len(batch))
-	entries := make([]store.StoredURL, 0, len(batch))
+	result := make([]BatchResponseItem, len(batch))
+	inputs := make([]service.ShortenInput, 0, len(batch))
+	positions := make([]int, 0, len(batch))
+	now := time.Now()
 
-	for _, item := range batch {
-		if strings.TrimSpace(item.OriginalURL) == "" {
+	for i, item := range batch {
+		result[i].CorrelationID = item.CorrelationID
+		expiresAt, err := service.ResolveExpiry(item.TTLSeconds, item.ExpiresAt, now)
+		if err != nil {
+			result[i].Status = string(service.BatchItemInvalid)
+			result[i].Error = err.Error()
 			continue
 		}
-		shortID := u.generateShorten(8)
-		shortURL, _ := url.JoinPath(config.Get().BaseURL, shortID)
-		entries = append(entries, store.StoredURL{
-			UUID:        shortID,
-			ShortURL:    shortID,
+		inputs = append(inputs, service.ShortenInput{
 			OriginalURL: item.OriginalURL,
+			Alias:       item.Alias,
 			UserID:      userID,
+			ExpiresAt:   expiresAt,
 		})
-		result = append(result, BatchResponseItem{
-			CorrelationID: item.CorrelationID,
-			ShortURL:      shortURL,
-		})
+		positions = append(positions, i)
 	}
 
-	if len(entries) == 0 {
-		http.Error(w, "empty valid batch", http.StatusBadRequest)
-		return
-	}
-
-	if err := u.service.BatchSave(ctx, entries); err != nil {
+	items, err := u.service.ShortenBatch(ctx, inputs)
+	if err != nil {
 		u.logger.Errorf("batch save failed: %v", err)
 		http.Error(w, "could not save batch", http.StatusInternalServerError)
 		return
 	}
 
+	var created, existed, blocked, failed int
+	for j, item := range items {
+		res := &result[positions[j]]
+		res.Status = string(item.Status)
+		res.Error = item.Reason
+		res.Code = item.Code
+		if item.URL != nil {
+			res.ShortURL, _ = url.JoinPath(config.Get().BaseURL, item.URL.ShortURL)
+		}
+		switch item.Status {
+		case service.BatchItemCreated:
+			created++
+		case service.BatchItemExists:
+			existed++
+		case service.BatchItemBlocked:
+			blocked++
+		case service.BatchItemFailed:
+			failed++
+		}
+	}
+
+	// 201 if anything was saved, 409 if everything already existed, 500 if
+	// nothing was saved and something failed, 422 if nothing was saved and
+	// something was blocked, 400 if nothing in the batch was usable. The body
+	// always has every item.
+	status := http.StatusCreated
+	switch {
+	case created > 0:
+	case existed > 0:
+		status = http.StatusConflict
+	case failed > 0:
+		status = http.StatusInternalServerError
+	case blocked > 0:
+		status = http.StatusUnprocessableEntity
+	default:
+		status = http.StatusBadRequest
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		u.logger.Errorw("failed to encode response", "error", err)
 	}
@@ -300,7 +460,43 @@ func (u *URLShortener) DeleteUserURLSHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	u.service.MarkDeletedAsync(userID, ids)
+	if err := u.service.MarkDeletedAsync(ctx, userID, ids); err != nil {
+		u.logger.Errorf("failed to queue deletion: %v", err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
+
+func (u *URLShortener) UserURLStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(middleware.UserIDKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	stats, err := u.service.GetLinkStats(ctx, userID, id)
+	switch {
+	case errors.Is(err, service.ErrLinkNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrNotOwner):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	case errors.Is(err, service.ErrStatsNotAvailable):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	case err != nil:
+		u.logger.Errorf("failed to load stats for %q: %v", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		u.logger.Errorw("failed to encode response", "error", err)
+	}
+}
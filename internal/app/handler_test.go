@@ -1,11 +1,17 @@
 package app
 
 import (
+	"context"
 	"cuturl/internal/config"
+	"cuturl/internal/middleware"
+	"cuturl/internal/service"
 	"cuturl/internal/store"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -68,6 +74,19 @@ func TestOrigURLHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "not a url",
+			body:   "not a url",
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "application/json",
+				checkBody: func(t *testing.T, body string) {
+					assert.Contains(t, body, `"error":"invalid_url"`)
+					assert.Contains(t, body, `"code":"not_absolute"`)
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -159,6 +178,54 @@ func TestOrigURLJSONHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "custom alias",
+			body:   `{"url":"https://example.com/sale","alias":"summer-sale"}`,
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusCreated,
+				contentType: "application/json",
+				checkBody: func(t *testing.T, body string) {
+					assert.Contains(t, body, "/summer-sale")
+				},
+			},
+		},
+		{
+			name:   "alias already taken",
+			body:   `{"url":"https://example.com/other","alias":"summer-sale"}`,
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusConflict,
+				contentType: "text/plain; charset=utf-8",
+				checkBody: func(t *testing.T, body string) {
+					assert.Contains(t, body, "alias already taken")
+				},
+			},
+		},
+		{
+			name:   "reserved alias",
+			body:   `{"url":"https://example.com/","alias":"ping"}`,
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "text/plain; charset=utf-8",
+				checkBody: func(t *testing.T, body string) {
+					assert.Contains(t, body, "reserved")
+				},
+			},
+		},
+		{
+			name:   "javascript url",
+			body:   `{"url":"javascript:alert(1)"}`,
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "application/json",
+				checkBody: func(t *testing.T, body string) {
+					assert.Contains(t, body, `"code":"scheme_not_allowed"`)
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -188,3 +255,133 @@ func TestOrigURLJSONHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenBatchHandlerInvalidURLs(t *testing.T) {
+	config.Init()
+	u := NewURLShortener(zap.NewNop().Sugar(), store.NewInMemoryRepository())
+
+	r := chi.NewRouter()
+	r.Post("/api/shorten/batch", http.HandlerFunc(u.ShortenBatchHandler))
+
+	body := `[{"correlation_id":"1","original_url":"relative/path"},{"correlation_id":"2","original_url":"ftp://example.com/"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var items []BatchResponseItem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+	require.Len(t, items, 2)
+	assert.Equal(t, BatchResponseItem{CorrelationID: "1", Status: "invalid", Code: "not_absolute", Error: items[0].Error}, items[0])
+	assert.Equal(t, "scheme_not_allowed", items[1].Code)
+	assert.NotEmpty(t, items[1].Error)
+}
+
+func TestBlockedDestinations(t *testing.T) {
+	config.Init()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("phish.example\n"), 0o600))
+	checker, err := service.NewBlocklistChecker(path)
+	require.NoError(t, err)
+
+	logger := zap.NewNop().Sugar()
+	repo := store.NewInMemoryRepository()
+	u := &URLShortener{
+		logger:  logger,
+		repo:    repo,
+		service: service.NewURLService(repo, logger, service.Options{SafetyCheckers: []service.SafetyChecker{checker}}),
+	}
+	defer u.service.Close()
+
+	r := chi.NewRouter()
+	r.Post("/api/shorten", http.HandlerFunc(u.OrigURLJSONHandler))
+	r.Post("/api/shorten/batch", http.HandlerFunc(u.ShortenBatchHandler))
+	r.Get("/{id}", http.HandlerFunc(u.ShortURLHandler))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://login.phish.example/"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var rejected RejectedURLResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rejected))
+	assert.Equal(t, "unsafe_url", rejected.Error)
+	assert.Equal(t, "domain phish.example is blocklisted", rejected.Message)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(`[{"correlation_id":"1","original_url":"https://phish.example/a"}]`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var items []BatchResponseItem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+	require.Len(t, items, 1)
+	assert.Equal(t, "blocked", items[0].Status)
+
+	// A link stored before its destination was listed gets the warning page.
+	require.NoError(t, repo.Save(context.Background(), store.StoredURL{ShortURL: "old", OriginalURL: "https://phish.example/<b>"}))
+	req = httptest.NewRequest(http.MethodGet, "/old", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "https://phish.example/&lt;b&gt;")
+	assert.NotContains(t, w.Body.String(), "href=")
+}
+
+func TestShortURLHandlerRedirectLoop(t *testing.T) {
+	config.Init()
+	u := NewURLShortener(zap.NewNop().Sugar(), store.NewInMemoryRepository())
+	defer u.service.Close()
+	base := config.Get().BaseURL
+
+	ctx := context.Background()
+	require.NoError(t, u.repo.Save(ctx, store.StoredURL{ShortURL: "ping", OriginalURL: base + "pong"}))
+	require.NoError(t, u.repo.Save(ctx, store.StoredURL{ShortURL: "pong", OriginalURL: base + "ping"}))
+
+	r := chi.NewRouter()
+	r.Get("/{id}", http.HandlerFunc(u.ShortURLHandler))
+	r.Post("/", http.HandlerFunc(u.OrigURLHandler))
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusLoopDetected, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(base+"ping"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"short_link"`)
+}
+
+func TestShortURLHandlerRecordsClientBehindProxy(t *testing.T) {
+	config.Init()
+	u := NewURLShortener(zap.NewNop().Sugar(), store.NewInMemoryRepository())
+	u.proxies = middleware.TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}
+
+	ctx := context.Background()
+	require.NoError(t, u.repo.Save(ctx, store.StoredURL{ShortURL: "abc", OriginalURL: "https://example.com", UserID: "owner"}))
+
+	r := chi.NewRouter()
+	r.Get("/{id}", http.HandlerFunc(u.ShortURLHandler))
+	for _, client := range []string{"203.0.113.7", "198.51.100.7"} {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	u.service.Close()
+
+	// Both clicks came through the same proxy, but from different clients.
+	stats, err := u.service.GetLinkStats(ctx, "owner", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalClicks)
+	assert.Equal(t, int64(2), stats.UniqueVisitors)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// interstitialTemplate warns about a short link whose destination was blocked
+// after it was created. The destination is shown as text, never as a link.
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Warning: unsafe destination</title>
+</head>
+<body>
+<h1>This link has been blocked</h1>
+<p>The page this short link points to was flagged as unsafe: {{.Reason}}.</p>
+<p>Destination: <code>{{.Destination}}</code></p>
+</body>
+</html>
+`))
+
+func writeInterstitial(w http.ResponseWriter, destination, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	interstitialTemplate.Execute(w, struct{ Destination, Reason string }{destination, reason})
+}
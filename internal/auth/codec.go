@@ -5,7 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,33 +15,183 @@ import (
 )
 
 const (
-	cookieName = "auth_token"
+	DefaultCookieName = "auth_token"
+
+	// hostPrefix makes browsers accept the cookie only if it is Secure, has
+	// Path=/ and no Domain, so a sibling subdomain can't plant or overwrite it.
+	hostPrefix = "__Host-"
+
+	// tokenVersion prefixes tokens that carry a key ID and lifetime:
+	// v2|<key ID>|<user ID>|<issued at>|<expires at>|<MAC>, times in Unix
+	// seconds. Tokens from before it are <user ID>|<MAC>.
+	tokenVersion = "v2"
+
+	DefaultTokenTTL        = 30 * 24 * time.Hour
+	DefaultTokenRenewAfter = 24 * time.Hour
+)
+
+var (
+	ErrInvalidMAC   = errors.New("invalid mac")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Options control token lifetime and the cookie that carries the token. A
+// token older than RenewAfter is still valid but gets replaced by a fresh
+// one, so active users stay signed in and move to the current key; one older
+// than TTL is rejected. The cookie lives as long as the token.
+type Options struct {
+	TTL        time.Duration
+	RenewAfter time.Duration
+	Cookie     CookieOptions
+	// LegacyTokensUntil is when tokens in the format before v2, which carry
+	// no expiry, stop being accepted. Until then they are renewed into v2
+	// tokens on use. The zero value rejects them outright.
+	LegacyTokensUntil time.Time
+}
+
+type CookieOptions struct {
+	Name     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	// HostPrefix prepends "__Host-" to Name. It needs Secure and no Domain.
+	HostPrefix bool
+}
+
+func (c CookieOptions) name() string {
+	if c.HostPrefix {
+		return hostPrefix + c.Name
+	}
+	return c.Name
+}
+
+// Validate reports settings browsers would reject or that weaken the cookie.
+func (c CookieOptions) Validate() error {
+	switch {
+	case c.HostPrefix && !c.Secure:
+		return errors.New("the __Host- cookie prefix requires a Secure cookie")
+	case c.HostPrefix && c.Domain != "":
+		return errors.New("the __Host- cookie prefix does not allow a cookie domain")
+	case c.SameSite == http.SameSiteNoneMode && !c.Secure:
+		return errors.New("SameSite=None requires a Secure cookie")
+	}
+	return nil
+}
+
+// ParseSameSite maps "lax", "strict" or "none" to the cookie mode.
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("invalid SameSite mode %q: want lax, strict or none", mode)
+}
+
+var (
+	keyring   *Keyring
+	tokenOpts Options
+	now       = time.Now
 )
 
-var secret []byte
-var ErrInvalidMAC = errors.New("invalid mac")
+func Init(ring *Keyring, opts Options) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTokenTTL
+	}
+	if opts.RenewAfter <= 0 {
+		opts.RenewAfter = DefaultTokenRenewAfter
+	}
+	if opts.Cookie.Name == "" {
+		opts.Cookie.Name = DefaultCookieName
+	}
+	if opts.Cookie.SameSite == 0 {
+		opts.Cookie.SameSite = http.SameSiteLaxMode
+	}
+	keyring = ring
+	tokenOpts = opts
+}
 
-func Init(secretStr string) {
-	secret = []byte(secretStr)
+func activeKeyring() *Keyring {
+	if keyring == nil {
+		panic("auth not initialized: call auth.Init() before issuing or checking tokens")
+	}
+	return keyring
 }
 
-func createHMAC(data string) string {
+func createHMAC(secret []byte, data string) string {
 	mac := hmac.New(sha256.New, secret)
 	mac.Write([]byte(data))
 	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
 }
 
-func validateToken(token string) (string, error) {
+// Claims are what a valid token says about its holder. Tokens in the old
+// format have no key ID or times.
+type Claims struct {
+	UserID    string
+	KeyID     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NeedsRenewal reports whether the holder should be given a new token: it is
+// older than RenewAfter, or signed with a key that is no longer the active
+// one.
+func (c Claims) NeedsRenewal() bool {
+	return c.KeyID != activeKeyring().active().ID || now().Sub(c.IssuedAt) >= tokenOpts.RenewAfter
+}
+
+// ParseToken checks a token issued by IssueToken, or by the format before
+// it, and returns its claims.
+func ParseToken(token string) (Claims, error) {
+	ring := activeKeyring()
 	parts := strings.Split(token, "|")
-	if len(parts) != 2 {
-		return "", ErrInvalidMAC
+	switch {
+	case len(parts) == 2:
+		if !now().Before(tokenOpts.LegacyTokensUntil) {
+			return Claims{}, ErrTokenExpired
+		}
+		userID, sig := parts[0], parts[1]
+		for _, key := range ring.keys {
+			if hmac.Equal([]byte(sig), []byte(createHMAC(key.Secret, userID))) {
+				return Claims{UserID: userID}, nil
+			}
+		}
+		return Claims{}, ErrInvalidMAC
+	case len(parts) != 6 || parts[0] != tokenVersion:
+		return Claims{}, ErrInvalidMAC
+	}
+
+	secret, ok := ring.secret(parts[1])
+	if !ok {
+		return Claims{}, ErrInvalidMAC
+	}
+	payload := strings.Join(parts[:5], "|")
+	if !hmac.Equal([]byte(parts[5]), []byte(createHMAC(secret, payload))) {
+		return Claims{}, ErrInvalidMAC
+	}
+	iat, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return Claims{}, ErrInvalidMAC
+	}
+	exp, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return Claims{}, ErrInvalidMAC
 	}
-	userID, sig := parts[0], parts[1]
-	expectedSig := createHMAC(userID)
-	if !hmac.Equal([]byte(sig), []byte(expectedSig)) {
-		return "", ErrInvalidMAC
+
+	claims := Claims{UserID: parts[2], KeyID: parts[1], IssuedAt: time.Unix(iat, 0), ExpiresAt: time.Unix(exp, 0)}
+	if !now().Before(claims.ExpiresAt) {
+		return Claims{}, ErrTokenExpired
 	}
-	return userID, nil
+	return claims, nil
+}
+
+// ValidateToken checks a token issued by IssueToken and returns its user ID.
+func ValidateToken(token string) (string, error) {
+	claims, err := ParseToken(token)
+	return claims.UserID, err
 }
 
 func GenerateToken() string {
@@ -47,21 +199,53 @@ func GenerateToken() string {
 	return userID
 }
 
+// IssueToken signs userID into the token format carried by the auth cookie
+// and by the gRPC auth_token metadata.
+func IssueToken(userID string) string {
+	key := activeKeyring().active()
+	iat := now()
+	payload := strings.Join([]string{
+		tokenVersion,
+		key.ID,
+		userID,
+		strconv.FormatInt(iat.Unix(), 10),
+		strconv.FormatInt(iat.Add(tokenOpts.TTL).Unix(), 10),
+	}, "|")
+	return payload + "|" + createHMAC(key.Secret, payload)
+}
+
 func SetAuthCookie(w http.ResponseWriter, userID string) {
-	token := userID + "|" + createHMAC(userID)
+	token := IssueToken(userID)
+	c := tokenOpts.Cookie
 	http.SetCookie(w, &http.Cookie{
-		Name:     cookieName,
+		Name:     c.name(),
 		Value:    token,
 		Path:     "/",
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
 		HttpOnly: true,
-		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		Expires:  now().Add(tokenOpts.TTL),
+		MaxAge:   int(tokenOpts.TTL.Seconds()),
 	})
 }
 
-func GetUserIDFromRequest(r *http.Request) (string, error) {
-	cookie, err := r.Cookie(cookieName)
+// HasAuthCookie reports whether the request carries an auth cookie at all,
+// valid or not.
+func HasAuthCookie(r *http.Request) bool {
+	_, err := r.Cookie(tokenOpts.Cookie.name())
+	return err == nil
+}
+
+func ClaimsFromRequest(r *http.Request) (Claims, error) {
+	cookie, err := r.Cookie(tokenOpts.Cookie.name())
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
-	return validateToken(cookie.Value)
+	return ParseToken(cookie.Value)
+}
+
+func GetUserIDFromRequest(r *http.Request) (string, error) {
+	claims, err := ClaimsFromRequest(r)
+	return claims.UserID, err
 }
@@ -1,2 +1,191 @@
 package auth
 
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initKeys(t *testing.T, opts Options, keys ...Key) {
+	t.Helper()
+	ring, err := NewKeyring(keys...)
+	require.NoError(t, err)
+	Init(ring, opts)
+}
+
+// at pins the clock for the rest of the test.
+func at(t *testing.T, ts time.Time) {
+	t.Helper()
+	now = func() time.Time { return ts }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestTokenLifetime(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	at(t, start)
+	initKeys(t, Options{TTL: 10 * time.Hour, RenewAfter: time.Hour}, Key{ID: "k1", Secret: []byte("secret-1")})
+
+	token := IssueToken("user-1")
+	assert.True(t, strings.HasPrefix(token, "v2|k1|user-1|"))
+
+	claims, err := ParseToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, Claims{UserID: "user-1", KeyID: "k1", IssuedAt: start, ExpiresAt: start.Add(10 * time.Hour)}, claims)
+	assert.False(t, claims.NeedsRenewal())
+
+	at(t, start.Add(2*time.Hour))
+	claims, err = ParseToken(token)
+	require.NoError(t, err)
+	assert.True(t, claims.NeedsRenewal())
+
+	at(t, start.Add(10*time.Hour))
+	_, err = ParseToken(token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	// The times are covered by the MAC.
+	at(t, start)
+	parts := strings.Split(token, "|")
+	parts[4] = "9999999999"
+	_, err = ParseToken(strings.Join(parts, "|"))
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+
+	for _, bad := range []string{"", "user-1", "a|b|c", "v3|k1|user-1|1|2|sig"} {
+		_, err = ParseToken(bad)
+		assert.ErrorIs(t, err, ErrInvalidMAC, bad)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	at(t, start)
+	opts := Options{LegacyTokensUntil: start.Add(time.Hour)}
+	old := Key{ID: "old", Secret: []byte("old-secret")}
+	initKeys(t, opts, old)
+	oldToken := IssueToken("user-1")
+	legacyToken := "user-2|" + createHMAC(old.Secret, "user-2")
+
+	initKeys(t, opts, Key{ID: "new", Secret: []byte("new-secret")}, old)
+	claims, err := ParseToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.True(t, claims.NeedsRenewal(), "tokens signed with a retired key are renewed")
+
+	claims, err = ParseToken(legacyToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", claims.UserID)
+	assert.True(t, claims.NeedsRenewal(), "old-format tokens are renewed")
+
+	at(t, start.Add(time.Hour))
+	_, err = ParseToken(legacyToken)
+	assert.ErrorIs(t, err, ErrTokenExpired, "old-format tokens are refused after the cutoff")
+	initKeys(t, Options{}, Key{ID: "new", Secret: []byte("new-secret")}, old)
+	_, err = ParseToken(legacyToken)
+	assert.ErrorIs(t, err, ErrTokenExpired, "old-format tokens are refused unless enabled")
+	at(t, start)
+	initKeys(t, opts, Key{ID: "new", Secret: []byte("new-secret")}, old)
+
+	claims, err = ParseToken(IssueToken("user-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", claims.KeyID)
+	assert.False(t, claims.NeedsRenewal())
+
+	initKeys(t, opts, Key{ID: "new", Secret: []byte("new-secret")})
+	_, err = ParseToken(oldToken)
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+	_, err = ParseToken(legacyToken)
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+}
+
+func TestAuthCookie(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	at(t, start)
+	initKeys(t, Options{
+		TTL: 2 * time.Hour,
+		Cookie: CookieOptions{
+			Name:       "sid",
+			Secure:     true,
+			SameSite:   http.SameSiteStrictMode,
+			HostPrefix: true,
+		},
+	}, Key{ID: "k1", Secret: []byte("secret-1")})
+
+	w := httptest.NewRecorder()
+	SetAuthCookie(w, "user-1")
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	c := cookies[0]
+	assert.Equal(t, "__Host-sid", c.Name)
+	assert.Equal(t, "/", c.Path)
+	assert.Empty(t, c.Domain)
+	assert.True(t, c.Secure)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, c.SameSite)
+	assert.Equal(t, 7200, c.MaxAge)
+	assert.Equal(t, start.Add(2*time.Hour).UTC(), c.Expires)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(c)
+	assert.True(t, HasAuthCookie(req))
+	userID, err := GetUserIDFromRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+
+	// The unprefixed name is someone else's cookie.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: c.Value})
+	assert.False(t, HasAuthCookie(req))
+}
+
+func TestCookieOptionsValidate(t *testing.T) {
+	assert.NoError(t, CookieOptions{Name: "auth_token", SameSite: http.SameSiteLaxMode}.Validate())
+	assert.NoError(t, CookieOptions{Name: "auth_token", Secure: true, HostPrefix: true}.Validate())
+	assert.Error(t, CookieOptions{Name: "auth_token", HostPrefix: true}.Validate())
+	assert.Error(t, CookieOptions{Name: "auth_token", Secure: true, Domain: "example.com", HostPrefix: true}.Validate())
+	assert.Error(t, CookieOptions{Name: "auth_token", SameSite: http.SameSiteNoneMode}.Validate())
+
+	mode, err := ParseSameSite("Strict")
+	require.NoError(t, err)
+	assert.Equal(t, http.SameSiteStrictMode, mode)
+	_, err = ParseSameSite("sometimes")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyring(t *testing.T) {
+	_, err := LoadKeyring("", "", "")
+	assert.ErrorIs(t, err, ErrNoSecret)
+
+	ring, err := LoadKeyring("b:two, a:one", "ignored", "")
+	require.NoError(t, err)
+	assert.Equal(t, "b", ring.active().ID)
+	secret, ok := ring.secret("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("one"), secret)
+
+	ring, err = LoadKeyring("", "plain", "")
+	require.NoError(t, err)
+	assert.Equal(t, Key{ID: defaultKeyID, Secret: []byte("plain")}, ring.active())
+
+	for _, bad := range []string{"nocolon", "a:one,a:two", "a:", ":secret"} {
+		_, err = LoadKeyring(bad, "", "")
+		assert.Error(t, err, bad)
+	}
+
+	// A missing file gets a random key that is reused on the next start.
+	path := filepath.Join(t.TempDir(), "auth.keys")
+	first, err := LoadKeyring("", "", path)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(first.active().Secret), 32)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	second, err := LoadKeyring("", "", path)
+	require.NoError(t, err)
+	assert.Equal(t, first.active(), second.active())
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoSecret is returned by LoadKeyring when no secret source is configured.
+var ErrNoSecret = errors.New("no auth secret configured: set AUTH_KEYS, AUTH_SECRET or AUTH_SECRET_FILE")
+
+// defaultKeyID names the key given by a bare AUTH_SECRET, so it can be listed
+// in AUTH_KEYS under the same ID when rotating away from it.
+const defaultKeyID = "default"
+
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring signs new tokens with its first key and accepts tokens signed with
+// any of them. To rotate, put a new key first and keep the old one until the
+// tokens it signed have been renewed or have expired, then drop it.
+type Keyring struct {
+	keys []Key
+}
+
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoSecret
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, "|:,") {
+			return nil, fmt.Errorf("invalid key ID %q", k.ID)
+		}
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("key %q has an empty secret", k.ID)
+		}
+		if _, dup := seen[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		seen[k.ID] = struct{}{}
+	}
+	return &Keyring{keys: keys}, nil
+}
+
+func (k *Keyring) active() Key {
+	return k.keys[0]
+}
+
+func (k *Keyring) secret(id string) ([]byte, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key.Secret, true
+		}
+	}
+	return nil, false
+}
+
+// ParseKeys reads "id:secret" entries separated by commas or newlines. Blank
+// lines and lines starting with '#' are skipped.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			id, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+			if !found {
+				return nil, fmt.Errorf("invalid key %q: want id:secret", id)
+			}
+			keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+		}
+	}
+	return keys, nil
+}
+
+// LoadKeyring builds the keyring from the first source that is set: a key
+// list, a single secret, or a file in the key list format. A missing file is
+// created with a random key, so the secret survives restarts.
+func LoadKeyring(keys, secret, secretFile string) (*Keyring, error) {
+	switch {
+	case keys != "":
+		parsed, err := ParseKeys(keys)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyring(parsed...)
+	case secret != "":
+		return NewKeyring(Key{ID: defaultKeyID, Secret: []byte(secret)})
+	case secretFile != "":
+		return loadSecretFile(secretFile)
+	}
+	return nil, ErrNoSecret
+}
+
+func loadSecretFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = generateSecretFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewKeyring(keys...)
+}
+
+func generateSecretFile(path string) ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	data := []byte(fmt.Sprintf("k%s:%s\n", time.Now().UTC().Format("20060102"), base64.RawURLEncoding.EncodeToString(b)))
+
+	// O_EXCL: if another process won the race, use its key instead.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -1,10 +1,15 @@
 package config
 
 import (
+	"cuturl/internal/service"
 	"flag"
 	"log"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -12,7 +17,71 @@ type Config struct {
 	BaseURL         string
 	FileStoragePath string
 	DBConnection    string
+	DBDriver        string
 	AuthSecret      string
+	AuthKeys        string
+	AuthSecretFile  string
+	GRPCAddress     string
+	// MetricsAddress serves /metrics on a listener of its own instead of
+	// the public one.
+	MetricsAddress string
+
+	ExpirySweepInterval time.Duration
+	ShutdownTimeout     time.Duration
+	FileCompactInterval time.Duration
+
+	DeleteQueueSize     int
+	DeleteBatchSize     int
+	DeleteFlushInterval time.Duration
+
+	IDStrategy  string
+	IDAlphabet  string
+	IDLength    int
+	IDRangeSize int
+
+	CacheSize int
+	CacheTTL  time.Duration
+
+	DedupScope string
+
+	AllowedURLSchemes   []string
+	StripTrackingParams bool
+
+	SafetyBlocklist      string
+	SafetyHashPrefixes   string
+	SafetyReloadInterval time.Duration
+
+	ShortenerHosts  []string
+	ShortLinkPolicy string
+
+	AuthTokenTTL        time.Duration
+	AuthTokenRenewAfter time.Duration
+	// AuthLegacyTokensUntil ends acceptance of pre-v2 tokens, which never
+	// expire on their own; zero rejects them.
+	AuthLegacyTokensUntil time.Time
+
+	// The auth cookie lives as long as the token it carries (AuthTokenTTL).
+	AuthCookieName       string
+	AuthCookieDomain     string
+	AuthCookieSecure     bool
+	AuthCookieSameSite   string
+	AuthCookieHostPrefix bool
+	// CSRFTrustedOrigins are origins besides BaseURL's allowed to send
+	// cookie-authenticated mutating requests.
+	CSRFTrustedOrigins []string
+
+	CreateRateLimit   RateLimit
+	RedirectRateLimit RateLimit
+	DeleteRateLimit   RateLimit
+	// TrustedProxies may name the real client in X-Forwarded-For.
+	TrustedProxies []netip.Prefix
+}
+
+// RateLimit allows Requests per Per, in bursts of up to Requests. The zero
+// value means no limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
 }
 
 var (
@@ -25,8 +94,45 @@ func Init() {
 		flagRunAddr := flag.String("a", "", "http server run address")
 		flagBaseURL := flag.String("b", "", "base url for short address")
 		flagFileStoragePath := flag.String("f", "", "path for file storage")
-		flagDBConnection := flag.String("d", "", "database connection string")
+		flagDBConnection := flag.String("d", "", "database connection string (sqlite://path selects SQLite)")
 		flagAuthSecret := flag.String("s", "", "auth secret for signing tokens")
+		flagGRPCAddress := flag.String("g", "", "grpc server address (disabled when empty)")
+		flagMetricsAddress := flag.String("metrics-addr", "", "serve /metrics on this address only, instead of the main server")
+		flagExpirySweepInterval := flag.Duration("expiry-sweep-interval", 0, "how often expired links are tombstoned")
+		flagFileCompactInterval := flag.Duration("file-compact-interval", 0, "how often the storage file is compacted when it holds superseded records")
+		flagShutdownTimeout := flag.Duration("shutdown-timeout", 0, "grace period for in-flight requests and pending deletions on shutdown")
+		flagDeleteQueueSize := flag.Int("delete-queue-size", 0, "pending delete requests before DELETE calls block")
+		flagDeleteBatchSize := flag.Int("delete-batch-size", 0, "max short IDs per delete write (1 to 30000)")
+		flagDeleteFlushInterval := flag.Duration("delete-flush-interval", 0, "max delay before pending deletions are flushed")
+		flagIDStrategy := flag.String("id-strategy", "", "short ID generator: random, sequential, hash or range")
+		flagIDAlphabet := flag.String("id-alphabet", "", "characters used in generated short IDs: letters, digits, - _ and ~")
+		flagIDLength := flag.Int("id-length", 0, "length of generated short IDs")
+		flagIDRangeSize := flag.Int("id-range-size", 0, "IDs leased per block by the range strategy")
+		flagCacheSize := flag.Int("cache-size", 0, "short IDs kept in the lookup cache (negative disables it)")
+		flagCacheTTL := flag.Duration("cache-ttl", 0, "how long cached lookups, including misses, are trusted")
+		flagDedupScope := flag.String("dedup-scope", "", "who shares a link for the same URL: global, user or none")
+		flagAllowedURLSchemes := flag.String("url-schemes", "", "comma-separated schemes accepted in original URLs")
+		flagStripTrackingParams := flag.Bool("strip-tracking-params", false, "remove utm_* and similar parameters from original URLs")
+		flagSafetyBlocklist := flag.String("safety-blocklist", "", "file of blocked domains and URL patterns")
+		flagSafetyHashPrefixes := flag.String("safety-hash-prefixes", "", "file of hex SHA-256 prefixes of unsafe URL expressions")
+		flagSafetyReloadInterval := flag.Duration("safety-reload-interval", 0, "how often the safety files are checked for changes")
+		flagShortenerHosts := flag.String("shortener-hosts", "", "comma-separated hosts of other URL shorteners")
+		flagShortLinkPolicy := flag.String("short-link-policy", "", "what to do with original URLs that are short links: reject or resolve")
+		flagCreateRateLimit := flag.String("rate-limit-create", "", "requests per client to create links, e.g. 30/m, or off (the default)")
+		flagRedirectRateLimit := flag.String("rate-limit-redirect", "", "requests per client to follow short links, e.g. 600/m, or off (the default)")
+		flagDeleteRateLimit := flag.String("rate-limit-delete", "", "requests per client to delete links, e.g. 30/m, or off (the default)")
+		flagTrustedProxies := flag.String("trusted-proxies", "", "comma-separated IPs or CIDRs of proxies whose X-Forwarded-For is trusted")
+		flagAuthKeys := flag.String("auth-keys", "", "comma-separated id:secret signing keys, the first one active")
+		flagAuthSecretFile := flag.String("auth-secret-file", "", "file holding the signing keys, created with a random key if missing; defaults to the storage file path plus .auth when no secret is set")
+		flagAuthTokenTTL := flag.Duration("auth-token-ttl", 0, "how long an auth token is valid")
+		flagAuthTokenRenewAfter := flag.Duration("auth-token-renew-after", 0, "token age after which a fresh one is issued")
+		flagAuthLegacyTokensUntil := flag.String("auth-legacy-tokens-until", "", "date (YYYY-MM-DD or RFC 3339) until which old-format auth tokens are accepted and renewed")
+		flagAuthCookieName := flag.String("auth-cookie-name", "", "name of the auth cookie")
+		flagAuthCookieDomain := flag.String("auth-cookie-domain", "", "domain attribute of the auth cookie")
+		flagAuthCookieSecure := flag.Bool("auth-cookie-secure", false, "send the auth cookie over HTTPS only (default on for an https base URL)")
+		flagAuthCookieSameSite := flag.String("auth-cookie-samesite", "", "SameSite mode of the auth cookie: lax, strict or none")
+		flagAuthCookieHostPrefix := flag.Bool("auth-cookie-host-prefix", false, "prefix the auth cookie name with __Host-")
+		flagCSRFTrustedOrigins := flag.String("csrf-trusted-origins", "", "comma-separated origins besides the base URL allowed to send mutating requests")
 		flag.Parse()
 
 		defaultRunAddr := "localhost:8080"
@@ -34,12 +140,18 @@ func Init() {
 		defaultFileStoragePath := "/tmp/urls.json"
 		defaultDBConnection := ""
 		defaultAuthSecret := ""
+		defaultGRPCAddress := ""
+		defaultExpirySweepInterval := time.Minute
+		defaultShutdownTimeout := 15 * time.Second
 
 		runAddr := defaultRunAddr
 		baseURL := defaultBaseURL
 		fileStoragePath := defaultFileStoragePath
 		dbConnection := defaultDBConnection
 		authSecret := defaultAuthSecret
+		grpcAddress := defaultGRPCAddress
+		expirySweepInterval := defaultExpirySweepInterval
+		shutdownTimeout := defaultShutdownTimeout
 
 		if envRunAddr := os.Getenv("SERVER_ADDRESS"); envRunAddr != "" {
 			runAddr = envRunAddr
@@ -65,20 +177,403 @@ func Init() {
 			dbConnection = *flagDBConnection
 		}
 
+		// A sqlite:// DSN names a database file; anything else goes to Postgres.
+		dbDriver := ""
+		if dbConnection != "" {
+			dbDriver = "postgres"
+			if path, ok := strings.CutPrefix(dbConnection, "sqlite://"); ok {
+				dbDriver = "sqlite"
+				dbConnection = path
+			}
+		}
+
 		if envAuthSecret := os.Getenv("AUTH_SECRET"); envAuthSecret != "" {
 			authSecret = envAuthSecret
 		} else if *flagAuthSecret != "" {
 			authSecret = *flagAuthSecret
 		}
 
+		authKeys := ""
+		if envAuthKeys := os.Getenv("AUTH_KEYS"); envAuthKeys != "" {
+			authKeys = envAuthKeys
+		} else if *flagAuthKeys != "" {
+			authKeys = *flagAuthKeys
+		}
+		authSecretFile := ""
+		if envAuthSecretFile := os.Getenv("AUTH_SECRET_FILE"); envAuthSecretFile != "" {
+			authSecretFile = envAuthSecretFile
+		} else if *flagAuthSecretFile != "" {
+			authSecretFile = *flagAuthSecretFile
+		}
+		// With no secret configured, keep a generated one next to the storage
+		// file so tokens survive restarts without any setup.
+		if authKeys == "" && authSecret == "" && authSecretFile == "" {
+			authSecretFile = fileStoragePath + ".auth"
+			log.Printf("warning: no AUTH_KEYS, AUTH_SECRET or AUTH_SECRET_FILE set; using signing keys from %s", authSecretFile)
+		}
+		authTokenTTL := durationSetting("AUTH_TOKEN_TTL", *flagAuthTokenTTL, 30*24*time.Hour)
+		authTokenRenewAfter := durationSetting("AUTH_TOKEN_RENEW_AFTER", *flagAuthTokenRenewAfter, 24*time.Hour)
+		if authTokenTTL <= 0 || authTokenRenewAfter <= 0 || authTokenRenewAfter >= authTokenTTL {
+			log.Fatalf("invalid AUTH_TOKEN_TTL %s / AUTH_TOKEN_RENEW_AFTER %s: both must be positive, renewal before expiry", authTokenTTL, authTokenRenewAfter)
+		}
+
+		authLegacyTokensUntil := time.Time{}
+		legacyUntil := os.Getenv("AUTH_LEGACY_TOKENS_UNTIL")
+		if legacyUntil == "" {
+			legacyUntil = *flagAuthLegacyTokensUntil
+		}
+		if legacyUntil != "" {
+			t, err := time.Parse(time.RFC3339, legacyUntil)
+			if err != nil {
+				t, err = time.Parse(time.DateOnly, legacyUntil)
+			}
+			if err != nil {
+				log.Fatalf("invalid AUTH_LEGACY_TOKENS_UNTIL %q: want YYYY-MM-DD or RFC 3339", legacyUntil)
+			}
+			authLegacyTokensUntil = t
+		}
+
+		authCookieName := "auth_token"
+		if envAuthCookieName := os.Getenv("AUTH_COOKIE_NAME"); envAuthCookieName != "" {
+			authCookieName = envAuthCookieName
+		} else if *flagAuthCookieName != "" {
+			authCookieName = *flagAuthCookieName
+		}
+		authCookieDomain := ""
+		if envAuthCookieDomain := os.Getenv("AUTH_COOKIE_DOMAIN"); envAuthCookieDomain != "" {
+			authCookieDomain = envAuthCookieDomain
+		} else if *flagAuthCookieDomain != "" {
+			authCookieDomain = *flagAuthCookieDomain
+		}
+		authCookieSameSite := "lax"
+		if envAuthCookieSameSite := os.Getenv("AUTH_COOKIE_SAMESITE"); envAuthCookieSameSite != "" {
+			authCookieSameSite = strings.ToLower(envAuthCookieSameSite)
+		} else if *flagAuthCookieSameSite != "" {
+			authCookieSameSite = strings.ToLower(*flagAuthCookieSameSite)
+		}
+		switch authCookieSameSite {
+		case "lax", "strict", "none":
+		default:
+			log.Fatalf("invalid AUTH_COOKIE_SAMESITE %q: want lax, strict or none", authCookieSameSite)
+		}
+		authCookieHostPrefix := boolSetting("AUTH_COOKIE_HOST_PREFIX", *flagAuthCookieHostPrefix)
+
+		csrfTrustedOrigins := ""
+		if envCSRFTrustedOrigins := os.Getenv("CSRF_TRUSTED_ORIGINS"); envCSRFTrustedOrigins != "" {
+			csrfTrustedOrigins = envCSRFTrustedOrigins
+		} else if *flagCSRFTrustedOrigins != "" {
+			csrfTrustedOrigins = *flagCSRFTrustedOrigins
+		}
+		var trustedOrigins []string
+		for _, origin := range strings.Split(csrfTrustedOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				trustedOrigins = append(trustedOrigins, origin)
+			}
+		}
+
+		if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+			grpcAddress = envGRPCAddress
+		} else if *flagGRPCAddress != "" {
+			grpcAddress = *flagGRPCAddress
+		}
+		metricsAddress := ""
+		if envMetricsAddress := os.Getenv("METRICS_ADDRESS"); envMetricsAddress != "" {
+			metricsAddress = envMetricsAddress
+		} else if *flagMetricsAddress != "" {
+			metricsAddress = *flagMetricsAddress
+		}
+
+		expirySweepInterval = durationSetting("EXPIRY_SWEEP_INTERVAL", *flagExpirySweepInterval, expirySweepInterval)
+		shutdownTimeout = durationSetting("SHUTDOWN_TIMEOUT", *flagShutdownTimeout, shutdownTimeout)
+		deleteQueueSize := intSetting("DELETE_QUEUE_SIZE", *flagDeleteQueueSize, 1024)
+		deleteBatchSize := intSetting("DELETE_BATCH_SIZE", *flagDeleteBatchSize, 500)
+		// Each ID is a bound parameter, and Postgres allows 65535 per query.
+		if deleteBatchSize <= 0 || deleteBatchSize > 30000 {
+			log.Fatalf("invalid DELETE_BATCH_SIZE %d: want 1 to 30000", deleteBatchSize)
+		}
+		deleteFlushInterval := durationSetting("DELETE_FLUSH_INTERVAL", *flagDeleteFlushInterval, time.Second)
+		if expirySweepInterval <= 0 {
+			log.Fatalf("invalid EXPIRY_SWEEP_INTERVAL %s", expirySweepInterval)
+		}
+		fileCompactInterval := durationSetting("FILE_COMPACT_INTERVAL", *flagFileCompactInterval, time.Hour)
+		if fileCompactInterval <= 0 {
+			log.Fatalf("invalid FILE_COMPACT_INTERVAL %s", fileCompactInterval)
+		}
+
+		idStrategy := "random"
+		if envIDStrategy := os.Getenv("ID_STRATEGY"); envIDStrategy != "" {
+			idStrategy = envIDStrategy
+		} else if *flagIDStrategy != "" {
+			idStrategy = *flagIDStrategy
+		}
+		switch idStrategy {
+		case "random", "sequential", "hash", "range":
+		default:
+			log.Fatalf("invalid ID_STRATEGY %q: want random, sequential, hash or range", idStrategy)
+		}
+
+		idAlphabet := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		if envIDAlphabet := os.Getenv("ID_ALPHABET"); envIDAlphabet != "" {
+			idAlphabet = envIDAlphabet
+		} else if *flagIDAlphabet != "" {
+			idAlphabet = *flagIDAlphabet
+		}
+		if err := service.ValidateIDAlphabet(idAlphabet); err != nil {
+			log.Fatalf("invalid ID_ALPHABET %q: %v", idAlphabet, err)
+		}
+
+		idLength := intSetting("ID_LENGTH", *flagIDLength, 8)
+		if idLength <= 0 {
+			log.Fatalf("invalid ID_LENGTH %d", idLength)
+		}
+		idRangeSize := intSetting("ID_RANGE_SIZE", *flagIDRangeSize, 1000)
+		if idRangeSize <= 0 {
+			log.Fatalf("invalid ID_RANGE_SIZE %d", idRangeSize)
+		}
+
+		cacheSize := intSetting("CACHE_SIZE", *flagCacheSize, 10000)
+		cacheTTL := durationSetting("CACHE_TTL", *flagCacheTTL, time.Minute)
+
+		dedupScope := "global"
+		if envDedupScope := os.Getenv("DEDUP_SCOPE"); envDedupScope != "" {
+			dedupScope = envDedupScope
+		} else if *flagDedupScope != "" {
+			dedupScope = *flagDedupScope
+		}
+		switch dedupScope {
+		case "global", "user", "none":
+		default:
+			log.Fatalf("invalid DEDUP_SCOPE %q: want global, user or none", dedupScope)
+		}
+
+		allowedURLSchemes := "http,https"
+		if envSchemes := os.Getenv("URL_SCHEMES"); envSchemes != "" {
+			allowedURLSchemes = envSchemes
+		} else if *flagAllowedURLSchemes != "" {
+			allowedURLSchemes = *flagAllowedURLSchemes
+		}
+		var schemes []string
+		for _, scheme := range strings.Split(allowedURLSchemes, ",") {
+			if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+				schemes = append(schemes, scheme)
+			}
+		}
+		if len(schemes) == 0 {
+			log.Fatalf("invalid URL_SCHEMES %q", allowedURLSchemes)
+		}
+		stripTrackingParams := boolSetting("STRIP_TRACKING_PARAMS", *flagStripTrackingParams)
+
+		safetyBlocklist := ""
+		if envBlocklist := os.Getenv("SAFETY_BLOCKLIST"); envBlocklist != "" {
+			safetyBlocklist = envBlocklist
+		} else if *flagSafetyBlocklist != "" {
+			safetyBlocklist = *flagSafetyBlocklist
+		}
+		safetyHashPrefixes := ""
+		if envHashPrefixes := os.Getenv("SAFETY_HASH_PREFIXES"); envHashPrefixes != "" {
+			safetyHashPrefixes = envHashPrefixes
+		} else if *flagSafetyHashPrefixes != "" {
+			safetyHashPrefixes = *flagSafetyHashPrefixes
+		}
+		safetyReloadInterval := durationSetting("SAFETY_RELOAD_INTERVAL", *flagSafetyReloadInterval, 30*time.Second)
+		if safetyReloadInterval <= 0 {
+			log.Fatalf("invalid SAFETY_RELOAD_INTERVAL %s", safetyReloadInterval)
+		}
+
+		shortenerHosts := "bit.ly,tinyurl.com,t.co,goo.gl,ow.ly,is.gd,buff.ly,rebrand.ly,cutt.ly,shorturl.at"
+		if envShortenerHosts := os.Getenv("SHORTENER_HOSTS"); envShortenerHosts != "" {
+			shortenerHosts = envShortenerHosts
+		} else if *flagShortenerHosts != "" {
+			shortenerHosts = *flagShortenerHosts
+		}
+		var hosts []string
+		for _, host := range strings.Split(shortenerHosts, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+
+		shortLinkPolicy := "reject"
+		if envShortLinkPolicy := os.Getenv("SHORT_LINK_POLICY"); envShortLinkPolicy != "" {
+			shortLinkPolicy = envShortLinkPolicy
+		} else if *flagShortLinkPolicy != "" {
+			shortLinkPolicy = *flagShortLinkPolicy
+		}
+		switch shortLinkPolicy {
+		case "reject", "resolve":
+		default:
+			log.Fatalf("invalid SHORT_LINK_POLICY %q: want reject or resolve", shortLinkPolicy)
+		}
+
+		// Secure by default when the service is reached over HTTPS; an
+		// explicit flag or environment value wins either way.
+		authCookieSecure := strings.HasPrefix(strings.ToLower(baseURL), "https://")
+		if flagSet("auth-cookie-secure") {
+			authCookieSecure = *flagAuthCookieSecure
+		}
+		authCookieSecure = boolSetting("AUTH_COOKIE_SECURE", authCookieSecure)
+
+		// Off unless configured: a sensible budget depends on the deployment.
+		createRateLimit := rateLimitSetting("RATE_LIMIT_CREATE", *flagCreateRateLimit, "off")
+		redirectRateLimit := rateLimitSetting("RATE_LIMIT_REDIRECT", *flagRedirectRateLimit, "off")
+		deleteRateLimit := rateLimitSetting("RATE_LIMIT_DELETE", *flagDeleteRateLimit, "off")
+
+		trustedProxiesList := ""
+		if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+			trustedProxiesList = envTrustedProxies
+		} else if *flagTrustedProxies != "" {
+			trustedProxiesList = *flagTrustedProxies
+		}
+		var trustedProxies []netip.Prefix
+		for _, entry := range strings.Split(trustedProxiesList, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(entry)
+				if addrErr != nil {
+					log.Fatalf("invalid TRUSTED_PROXIES entry %q: want an IP or CIDR", entry)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			trustedProxies = append(trustedProxies, prefix.Masked())
+		}
+
 		cfg = &Config{
 			RunAddress:      runAddr,
 			BaseURL:         baseURL,
 			FileStoragePath: fileStoragePath,
 			DBConnection:    dbConnection,
+			DBDriver:        dbDriver,
 			AuthSecret:      authSecret,
+			AuthKeys:        authKeys,
+			AuthSecretFile:  authSecretFile,
+			GRPCAddress:     grpcAddress,
+			MetricsAddress:  metricsAddress,
+
+			ExpirySweepInterval: expirySweepInterval,
+			ShutdownTimeout:     shutdownTimeout,
+			FileCompactInterval: fileCompactInterval,
+
+			DeleteQueueSize:     deleteQueueSize,
+			DeleteBatchSize:     deleteBatchSize,
+			DeleteFlushInterval: deleteFlushInterval,
+
+			IDStrategy:  idStrategy,
+			IDAlphabet:  idAlphabet,
+			IDLength:    idLength,
+			IDRangeSize: idRangeSize,
+
+			CacheSize: cacheSize,
+			CacheTTL:  cacheTTL,
+
+			DedupScope: dedupScope,
+
+			AllowedURLSchemes:   schemes,
+			StripTrackingParams: stripTrackingParams,
+
+			SafetyBlocklist:      safetyBlocklist,
+			SafetyHashPrefixes:   safetyHashPrefixes,
+			SafetyReloadInterval: safetyReloadInterval,
+
+			ShortenerHosts:  hosts,
+			ShortLinkPolicy: shortLinkPolicy,
+
+			AuthTokenTTL:        authTokenTTL,
+			AuthTokenRenewAfter: authTokenRenewAfter,
+
+			AuthLegacyTokensUntil: authLegacyTokensUntil,
+
+			AuthCookieName:       authCookieName,
+			AuthCookieDomain:     authCookieDomain,
+			AuthCookieSecure:     authCookieSecure,
+			AuthCookieSameSite:   authCookieSameSite,
+			AuthCookieHostPrefix: authCookieHostPrefix,
+			CSRFTrustedOrigins:   trustedOrigins,
+
+			CreateRateLimit:   createRateLimit,
+			RedirectRateLimit: redirectRateLimit,
+			DeleteRateLimit:   deleteRateLimit,
+			TrustedProxies:    trustedProxies,
+		}
+	})
+}
+
+// durationSetting and intSetting follow the same precedence as the string
+// settings above: environment first, then the flag, then the default.
+func durationSetting(env string, flagVal time.Duration, def time.Duration) time.Duration {
+	if v := os.Getenv(env); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", env, err)
+		}
+		return d
+	}
+	if flagVal != 0 {
+		return flagVal
+	}
+	return def
+}
+
+func intSetting(env string, flagVal int, def int) int {
+	if v := os.Getenv(env); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", env, err)
+		}
+		return n
+	}
+	if flagVal != 0 {
+		return flagVal
+	}
+	return def
+}
+
+// boolSetting lets the environment override the flag in either direction.
+func boolSetting(env string, flagVal bool) bool {
+	if v := os.Getenv(env); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", env, err)
+		}
+		return b
+	}
+	return flagVal
+}
+
+// flagSet reports whether the named flag was given on the command line, for
+// boolean settings whose default isn't false.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
 	})
+	return set
+}
+
+// rateLimitSetting parses "<requests>/<s|m|h>", or "off", with the usual
+// precedence.
+func rateLimitSetting(env string, flagVal string, def string) RateLimit {
+	v := def
+	if envVal := os.Getenv(env); envVal != "" {
+		v = envVal
+	} else if flagVal != "" {
+		v = flagVal
+	}
+	if v == "off" {
+		return RateLimit{}
+	}
+
+	n, unit, ok := strings.Cut(v, "/")
+	requests, err := strconv.Atoi(n)
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if !ok || err != nil || requests <= 0 || per == 0 {
+		log.Fatalf("invalid %s %q: want <requests>/<s|m|h> or off", env, v)
+	}
+	return RateLimit{Requests: requests, Per: per}
 }
 
 func Get() *Config {
@@ -0,0 +1,37 @@
+package grpcapi
+
+import (
+	"context"
+	"cuturl/internal/auth"
+	"cuturl/internal/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenMetadataKey carries the same signed token as the HTTP auth cookie.
+const TokenMetadataKey = "auth_token"
+
+// AuthInterceptor mirrors middleware.AuthMiddleware: a valid token selects the
+// user, otherwise a new user is created. New and renewed tokens are sent back
+// in the response header metadata.
+func AuthInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var claims auth.Claims
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if tokens := md.Get(TokenMetadataKey); len(tokens) > 0 {
+			claims, _ = auth.ParseToken(tokens[0])
+		}
+	}
+
+	userID := claims.UserID
+	if userID == "" {
+		userID = auth.GenerateToken()
+	}
+	if claims.UserID == "" || claims.NeedsRenewal() {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(TokenMetadataKey, auth.IssueToken(userID))); err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(context.WithValue(ctx, middleware.UserIDKey, userID), req)
+}
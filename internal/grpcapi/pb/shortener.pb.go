@@ -0,0 +1,803 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: shortener.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ShortenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Alias         string                 `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	TtlSeconds    int64                  `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenRequest) Reset() {
+	*x = ShortenRequest{}
+	mi := &file_shortener_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenRequest) ProtoMessage() {}
+
+func (x *ShortenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenRequest.ProtoReflect.Descriptor instead.
+func (*ShortenRequest) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ShortenRequest) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *ShortenRequest) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *ShortenRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *ShortenRequest) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+type ShortenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Result        string                 `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	AlreadyExists bool                   `protobuf:"varint,2,opt,name=already_exists,json=alreadyExists,proto3" json:"already_exists,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenResponse) Reset() {
+	*x = ShortenResponse{}
+	mi := &file_shortener_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenResponse) ProtoMessage() {}
+
+func (x *ShortenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenResponse.ProtoReflect.Descriptor instead.
+func (*ShortenResponse) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ShortenResponse) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *ShortenResponse) GetAlreadyExists() bool {
+	if x != nil {
+		return x.AlreadyExists
+	}
+	return false
+}
+
+type ShortenBatchItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CorrelationId string                 `protobuf:"bytes,1,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	OriginalUrl   string                 `protobuf:"bytes,2,opt,name=original_url,json=originalUrl,proto3" json:"original_url,omitempty"`
+	Alias         string                 `protobuf:"bytes,3,opt,name=alias,proto3" json:"alias,omitempty"`
+	TtlSeconds    int64                  `protobuf:"varint,4,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenBatchItem) Reset() {
+	*x = ShortenBatchItem{}
+	mi := &file_shortener_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenBatchItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenBatchItem) ProtoMessage() {}
+
+func (x *ShortenBatchItem) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenBatchItem.ProtoReflect.Descriptor instead.
+func (*ShortenBatchItem) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ShortenBatchItem) GetCorrelationId() string {
+	if x != nil {
+		return x.CorrelationId
+	}
+	return ""
+}
+
+func (x *ShortenBatchItem) GetOriginalUrl() string {
+	if x != nil {
+		return x.OriginalUrl
+	}
+	return ""
+}
+
+func (x *ShortenBatchItem) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *ShortenBatchItem) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *ShortenBatchItem) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+type ShortenBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*ShortenBatchItem    `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenBatchRequest) Reset() {
+	*x = ShortenBatchRequest{}
+	mi := &file_shortener_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenBatchRequest) ProtoMessage() {}
+
+func (x *ShortenBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenBatchRequest.ProtoReflect.Descriptor instead.
+func (*ShortenBatchRequest) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ShortenBatchRequest) GetItems() []*ShortenBatchItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type ShortenBatchResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CorrelationId string                 `protobuf:"bytes,1,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	ShortUrl      string                 `protobuf:"bytes,2,opt,name=short_url,json=shortUrl,proto3" json:"short_url,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenBatchResult) Reset() {
+	*x = ShortenBatchResult{}
+	mi := &file_shortener_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenBatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenBatchResult) ProtoMessage() {}
+
+func (x *ShortenBatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenBatchResult.ProtoReflect.Descriptor instead.
+func (*ShortenBatchResult) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ShortenBatchResult) GetCorrelationId() string {
+	if x != nil {
+		return x.CorrelationId
+	}
+	return ""
+}
+
+func (x *ShortenBatchResult) GetShortUrl() string {
+	if x != nil {
+		return x.ShortUrl
+	}
+	return ""
+}
+
+func (x *ShortenBatchResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ShortenBatchResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ShortenBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*ShortenBatchResult  `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortenBatchResponse) Reset() {
+	*x = ShortenBatchResponse{}
+	mi := &file_shortener_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortenBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortenBatchResponse) ProtoMessage() {}
+
+func (x *ShortenBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortenBatchResponse.ProtoReflect.Descriptor instead.
+func (*ShortenBatchResponse) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ShortenBatchResponse) GetItems() []*ShortenBatchResult {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type ResolveRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveRequest) Reset() {
+	*x = ResolveRequest{}
+	mi := &file_shortener_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveRequest) ProtoMessage() {}
+
+func (x *ResolveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveRequest.ProtoReflect.Descriptor instead.
+func (*ResolveRequest) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ResolveRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ResolveResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OriginalUrl   string                 `protobuf:"bytes,1,opt,name=original_url,json=originalUrl,proto3" json:"original_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveResponse) Reset() {
+	*x = ResolveResponse{}
+	mi := &file_shortener_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveResponse) ProtoMessage() {}
+
+func (x *ResolveResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveResponse.ProtoReflect.Descriptor instead.
+func (*ResolveResponse) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ResolveResponse) GetOriginalUrl() string {
+	if x != nil {
+		return x.OriginalUrl
+	}
+	return ""
+}
+
+type ListUserURLsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUserURLsRequest) Reset() {
+	*x = ListUserURLsRequest{}
+	mi := &file_shortener_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUserURLsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUserURLsRequest) ProtoMessage() {}
+
+func (x *ListUserURLsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUserURLsRequest.ProtoReflect.Descriptor instead.
+func (*ListUserURLsRequest) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{8}
+}
+
+type UserURL struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShortUrl      string                 `protobuf:"bytes,1,opt,name=short_url,json=shortUrl,proto3" json:"short_url,omitempty"`
+	OriginalUrl   string                 `protobuf:"bytes,2,opt,name=original_url,json=originalUrl,proto3" json:"original_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserURL) Reset() {
+	*x = UserURL{}
+	mi := &file_shortener_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserURL) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserURL) ProtoMessage() {}
+
+func (x *UserURL) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserURL.ProtoReflect.Descriptor instead.
+func (*UserURL) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *UserURL) GetShortUrl() string {
+	if x != nil {
+		return x.ShortUrl
+	}
+	return ""
+}
+
+func (x *UserURL) GetOriginalUrl() string {
+	if x != nil {
+		return x.OriginalUrl
+	}
+	return ""
+}
+
+type ListUserURLsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Urls          []*UserURL             `protobuf:"bytes,1,rep,name=urls,proto3" json:"urls,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUserURLsResponse) Reset() {
+	*x = ListUserURLsResponse{}
+	mi := &file_shortener_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUserURLsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUserURLsResponse) ProtoMessage() {}
+
+func (x *ListUserURLsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUserURLsResponse.ProtoReflect.Descriptor instead.
+func (*ListUserURLsResponse) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListUserURLsResponse) GetUrls() []*UserURL {
+	if x != nil {
+		return x.Urls
+	}
+	return nil
+}
+
+type DeleteUserURLsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []string               `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserURLsRequest) Reset() {
+	*x = DeleteUserURLsRequest{}
+	mi := &file_shortener_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserURLsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserURLsRequest) ProtoMessage() {}
+
+func (x *DeleteUserURLsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserURLsRequest.ProtoReflect.Descriptor instead.
+func (*DeleteUserURLsRequest) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DeleteUserURLsRequest) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type DeleteUserURLsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserURLsResponse) Reset() {
+	*x = DeleteUserURLsResponse{}
+	mi := &file_shortener_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserURLsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserURLsResponse) ProtoMessage() {}
+
+func (x *DeleteUserURLsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shortener_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserURLsResponse.ProtoReflect.Descriptor instead.
+func (*DeleteUserURLsResponse) Descriptor() ([]byte, []int) {
+	return file_shortener_proto_rawDescGZIP(), []int{12}
+}
+
+var File_shortener_proto protoreflect.FileDescriptor
+
+const file_shortener_proto_rawDesc = "" +
+	"\n" +
+	"\x0fshortener.proto\x12\fshortener.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x94\x01\n" +
+	"\x0eShortenRequest\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x14\n" +
+	"\x05alias\x18\x02 \x01(\tR\x05alias\x12\x1f\n" +
+	"\vttl_seconds\x18\x03 \x01(\x03R\n" +
+	"ttlSeconds\x129\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\"P\n" +
+	"\x0fShortenResponse\x12\x16\n" +
+	"\x06result\x18\x01 \x01(\tR\x06result\x12%\n" +
+	"\x0ealready_exists\x18\x02 \x01(\bR\ralreadyExists\"\xce\x01\n" +
+	"\x10ShortenBatchItem\x12%\n" +
+	"\x0ecorrelation_id\x18\x01 \x01(\tR\rcorrelationId\x12!\n" +
+	"\foriginal_url\x18\x02 \x01(\tR\voriginalUrl\x12\x14\n" +
+	"\x05alias\x18\x03 \x01(\tR\x05alias\x12\x1f\n" +
+	"\vttl_seconds\x18\x04 \x01(\x03R\n" +
+	"ttlSeconds\x129\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\"K\n" +
+	"\x13ShortenBatchRequest\x124\n" +
+	"\x05items\x18\x01 \x03(\v2\x1e.shortener.v1.ShortenBatchItemR\x05items\"\x86\x01\n" +
+	"\x12ShortenBatchResult\x12%\n" +
+	"\x0ecorrelation_id\x18\x01 \x01(\tR\rcorrelationId\x12\x1b\n" +
+	"\tshort_url\x18\x02 \x01(\tR\bshortUrl\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"N\n" +
+	"\x14ShortenBatchResponse\x126\n" +
+	"\x05items\x18\x01 \x03(\v2 .shortener.v1.ShortenBatchResultR\x05items\" \n" +
+	"\x0eResolveRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"4\n" +
+	"\x0fResolveResponse\x12!\n" +
+	"\foriginal_url\x18\x01 \x01(\tR\voriginalUrl\"\x15\n" +
+	"\x13ListUserURLsRequest\"I\n" +
+	"\aUserURL\x12\x1b\n" +
+	"\tshort_url\x18\x01 \x01(\tR\bshortUrl\x12!\n" +
+	"\foriginal_url\x18\x02 \x01(\tR\voriginalUrl\"A\n" +
+	"\x14ListUserURLsResponse\x12)\n" +
+	"\x04urls\x18\x01 \x03(\v2\x15.shortener.v1.UserURLR\x04urls\")\n" +
+	"\x15DeleteUserURLsRequest\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03ids\"\x18\n" +
+	"\x16DeleteUserURLsResponse2\xa6\x03\n" +
+	"\tShortener\x12F\n" +
+	"\aShorten\x12\x1c.shortener.v1.ShortenRequest\x1a\x1d.shortener.v1.ShortenResponse\x12U\n" +
+	"\fShortenBatch\x12!.shortener.v1.ShortenBatchRequest\x1a\".shortener.v1.ShortenBatchResponse\x12F\n" +
+	"\aResolve\x12\x1c.shortener.v1.ResolveRequest\x1a\x1d.shortener.v1.ResolveResponse\x12U\n" +
+	"\fListUserURLs\x12!.shortener.v1.ListUserURLsRequest\x1a\".shortener.v1.ListUserURLsResponse\x12[\n" +
+	"\x0eDeleteUserURLs\x12#.shortener.v1.DeleteUserURLsRequest\x1a$.shortener.v1.DeleteUserURLsResponseB\x1fZ\x1dcuturl/internal/grpcapi/pb;pbb\x06proto3"
+
+var (
+	file_shortener_proto_rawDescOnce sync.Once
+	file_shortener_proto_rawDescData []byte
+)
+
+func file_shortener_proto_rawDescGZIP() []byte {
+	file_shortener_proto_rawDescOnce.Do(func() {
+		file_shortener_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shortener_proto_rawDesc), len(file_shortener_proto_rawDesc)))
+	})
+	return file_shortener_proto_rawDescData
+}
+
+var file_shortener_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_shortener_proto_goTypes = []any{
+	(*ShortenRequest)(nil),         // 0: shortener.v1.ShortenRequest
+	(*ShortenResponse)(nil),        // 1: shortener.v1.ShortenResponse
+	(*ShortenBatchItem)(nil),       // 2: shortener.v1.ShortenBatchItem
+	(*ShortenBatchRequest)(nil),    // 3: shortener.v1.ShortenBatchRequest
+	(*ShortenBatchResult)(nil),     // 4: shortener.v1.ShortenBatchResult
+	(*ShortenBatchResponse)(nil),   // 5: shortener.v1.ShortenBatchResponse
+	(*ResolveRequest)(nil),         // 6: shortener.v1.ResolveRequest
+	(*ResolveResponse)(nil),        // 7: shortener.v1.ResolveResponse
+	(*ListUserURLsRequest)(nil),    // 8: shortener.v1.ListUserURLsRequest
+	(*UserURL)(nil),                // 9: shortener.v1.UserURL
+	(*ListUserURLsResponse)(nil),   // 10: shortener.v1.ListUserURLsResponse
+	(*DeleteUserURLsRequest)(nil),  // 11: shortener.v1.DeleteUserURLsRequest
+	(*DeleteUserURLsResponse)(nil), // 12: shortener.v1.DeleteUserURLsResponse
+	(*timestamppb.Timestamp)(nil),  // 13: google.protobuf.Timestamp
+}
+var file_shortener_proto_depIdxs = []int32{
+	13, // 0: shortener.v1.ShortenRequest.expires_at:type_name -> google.protobuf.Timestamp
+	13, // 1: shortener.v1.ShortenBatchItem.expires_at:type_name -> google.protobuf.Timestamp
+	2,  // 2: shortener.v1.ShortenBatchRequest.items:type_name -> shortener.v1.ShortenBatchItem
+	4,  // 3: shortener.v1.ShortenBatchResponse.items:type_name -> shortener.v1.ShortenBatchResult
+	9,  // 4: shortener.v1.ListUserURLsResponse.urls:type_name -> shortener.v1.UserURL
+	0,  // 5: shortener.v1.Shortener.Shorten:input_type -> shortener.v1.ShortenRequest
+	3,  // 6: shortener.v1.Shortener.ShortenBatch:input_type -> shortener.v1.ShortenBatchRequest
+	6,  // 7: shortener.v1.Shortener.Resolve:input_type -> shortener.v1.ResolveRequest
+	8,  // 8: shortener.v1.Shortener.ListUserURLs:input_type -> shortener.v1.ListUserURLsRequest
+	11, // 9: shortener.v1.Shortener.DeleteUserURLs:input_type -> shortener.v1.DeleteUserURLsRequest
+	1,  // 10: shortener.v1.Shortener.Shorten:output_type -> shortener.v1.ShortenResponse
+	5,  // 11: shortener.v1.Shortener.ShortenBatch:output_type -> shortener.v1.ShortenBatchResponse
+	7,  // 12: shortener.v1.Shortener.Resolve:output_type -> shortener.v1.ResolveResponse
+	10, // 13: shortener.v1.Shortener.ListUserURLs:output_type -> shortener.v1.ListUserURLsResponse
+	12, // 14: shortener.v1.Shortener.DeleteUserURLs:output_type -> shortener.v1.DeleteUserURLsResponse
+	10, // [10:15] is the sub-list for method output_type
+	5,  // [5:10] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_shortener_proto_init() }
+func file_shortener_proto_init() {
+	if File_shortener_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shortener_proto_rawDesc), len(file_shortener_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_shortener_proto_goTypes,
+		DependencyIndexes: file_shortener_proto_depIdxs,
+		MessageInfos:      file_shortener_proto_msgTypes,
+	}.Build()
+	File_shortener_proto = out.File
+	file_shortener_proto_goTypes = nil
+	file_shortener_proto_depIdxs = nil
+}
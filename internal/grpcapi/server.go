@@ -0,0 +1,178 @@
+package grpcapi
+
+//go:generate protoc --proto_path=../../api/proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative shortener.proto
+
+import (
+	"context"
+	"cuturl/internal/grpcapi/pb"
+	"cuturl/internal/middleware"
+	"cuturl/internal/service"
+	"cuturl/internal/store"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Server struct {
+	pb.UnimplementedShortenerServer
+	service *service.URLService
+	logger  *zap.SugaredLogger
+	baseURL string
+}
+
+func NewServer(svc *service.URLService, logger *zap.SugaredLogger, baseURL string) *grpc.Server {
+	g := grpc.NewServer(grpc.UnaryInterceptor(AuthInterceptor))
+	pb.RegisterShortenerServer(g, &Server{service: svc, logger: logger, baseURL: baseURL})
+	return g
+}
+
+func (s *Server) shortURL(id string) string {
+	u, _ := url.JoinPath(s.baseURL, id)
+	return u
+}
+
+func timestampOrNil(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+func userID(ctx context.Context) string {
+	id, _ := ctx.Value(middleware.UserIDKey).(string)
+	return id
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if strings.TrimSpace(req.GetUrl()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty url")
+	}
+	expiresAt, err := service.ResolveExpiry(req.GetTtlSeconds(), timestampOrNil(req.GetExpiresAt()), time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	entry, created, err := s.service.Shorten(ctx, service.ShortenInput{
+		OriginalURL: req.GetUrl(),
+		Alias:       req.GetAlias(),
+		UserID:      userID(ctx),
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &pb.ShortenResponse{Result: s.shortURL(entry.ShortURL), AlreadyExists: !created}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	results := make([]*pb.ShortenBatchResult, len(req.GetItems()))
+	inputs := make([]service.ShortenInput, 0, len(req.GetItems()))
+	positions := make([]int, 0, len(req.GetItems()))
+	now := time.Now()
+	for i, item := range req.GetItems() {
+		results[i] = &pb.ShortenBatchResult{CorrelationId: item.GetCorrelationId()}
+		expiresAt, err := service.ResolveExpiry(item.GetTtlSeconds(), timestampOrNil(item.GetExpiresAt()), now)
+		if err != nil {
+			results[i].Status = string(service.BatchItemInvalid)
+			results[i].Error = err.Error()
+			continue
+		}
+		inputs = append(inputs, service.ShortenInput{
+			OriginalURL: item.GetOriginalUrl(),
+			Alias:       item.GetAlias(),
+			UserID:      userID(ctx),
+			ExpiresAt:   expiresAt,
+		})
+		positions = append(positions, i)
+	}
+
+	items, err := s.service.ShortenBatch(ctx, inputs)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	for j, item := range items {
+		res := results[positions[j]]
+		res.Status = string(item.Status)
+		res.Error = item.Reason
+		if item.URL != nil {
+			res.ShortUrl = s.shortURL(item.URL.ShortURL)
+		}
+	}
+	return &pb.ShortenBatchResponse{Items: results}, nil
+}
+
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	entry, err := s.service.GetByShortID(ctx, req.GetId())
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "short link not found")
+	}
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	if entry.IsDeleted || entry.IsExpired(time.Now()) {
+		return nil, status.Error(codes.FailedPrecondition, "short link is gone")
+	}
+	if err := s.service.CheckRedirectLoop(ctx, entry); errors.Is(err, service.ErrRedirectLoop) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		s.logger.Errorf("redirect loop check for %q failed: %v", req.GetId(), err)
+	}
+	verdict, err := s.service.CheckSafety(ctx, entry.OriginalURL)
+	if err != nil {
+		s.logger.Errorf("safety check for %q failed: %v", entry.OriginalURL, err)
+	}
+	if verdict.Blocked {
+		return nil, status.Error(codes.FailedPrecondition, "destination is blocked: "+verdict.Reason)
+	}
+	return &pb.ResolveResponse{OriginalUrl: entry.OriginalURL}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	urls, err := s.service.GetUserURLs(ctx, userID(ctx))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls))}
+	for _, entry := range urls {
+		resp.Urls = append(resp.Urls, &pb.UserURL{
+			ShortUrl:    s.shortURL(entry.ShortURL),
+			OriginalUrl: entry.OriginalURL,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if len(req.GetIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no ids")
+	}
+	if err := s.service.MarkDeletedAsync(ctx, userID(ctx), req.GetIds()); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+func (s *Server) toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrReservedAlias),
+		errors.Is(err, service.ErrInvalidURL), errors.Is(err, service.ErrUnsafeURL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrAliasTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	s.logger.Errorf("grpc call failed: %v", err)
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,72 @@
+package grpcapi
+
+import (
+	"context"
+	"cuturl/internal/auth"
+	"cuturl/internal/grpcapi/pb"
+	"cuturl/internal/service"
+	"cuturl/internal/store"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newClient(t *testing.T) pb.ShortenerClient {
+	t.Helper()
+	ring, err := auth.NewKeyring(auth.Key{ID: "test", Secret: []byte("test-secret")})
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+
+	svc := service.NewURLService(store.NewInMemoryRepository(), zap.NewNop().Sugar(), service.Options{})
+	srv := NewServer(svc, zap.NewNop().Sugar(), "http://short.test/")
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		srv.Stop()
+		svc.Close()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func TestShortenAndResolve(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com", Alias: "example"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://short.test/example", resp.GetResult())
+	require.Len(t, header.Get(TokenMetadataKey), 1)
+
+	authed := metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, header.Get(TokenMetadataKey)[0])
+	list, err := client.ListUserURLs(authed, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, "https://example.com", list.GetUrls()[0].GetOriginalUrl())
+
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{Id: "example"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", resolved.GetOriginalUrl())
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://other.example", Alias: "example"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"cuturl/internal/store"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	Redirects = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Short link lookups by result (hit, miss, gone, loop, blocked).",
+	}, []string{"result"})
+
+	RepositoryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_operation_duration_seconds",
+		Help:      "Repository operation latency by backend, operation and outcome.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"backend", "operation", "status"})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the rate limiter, by route class (create, redirect, delete).",
+	}, []string{"class"})
+
+	GzipResponses = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gzip_responses_total",
+		Help:      "Responses sent with gzip content encoding.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+func RegisterDeleteQueueDepth(depth func() int) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "delete_queue_depth",
+		Help:      "Delete requests waiting for the batching worker.",
+	}, func() float64 {
+		return float64(depth())
+	})
+}
+
+// RegisterCacheStats exposes the hit and miss counts of a short ID cache.
+func RegisterCacheStats(stats func() store.CacheStats) {
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Short ID lookups answered from the cache, including cached misses.",
+	}, func() float64 {
+		return float64(stats().Hits)
+	})
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Short ID lookups that went to the repository.",
+	}, func() float64 {
+		return float64(stats().Misses)
+	})
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	errClicksUnsupported = errors.New("backend does not store clicks")
+	errRangesUnsupported = errors.New("backend does not lease id ranges")
+	errKeysUnsupported   = errors.New("backend does not store api keys")
+	errPagingUnsupported = errors.New("backend does not page through urls")
+	errCountUnsupported  = errors.New("backend does not count urls")
+)
+
+// InstrumentedRepository records the latency of every call to the wrapped
+// repository under the given backend label. It also forwards the optional
+// ClickStore, IDRangeLeaser, APIKeyStore, URLPager, URLCounter and io.Closer
+// capabilities of the wrapped value; see store.Wrapper for checking which it
+// really has.
+type InstrumentedRepository struct {
+	next    store.Repository
+	backend string
+}
+
+func InstrumentRepository(next store.Repository, backend string) *InstrumentedRepository {
+	return &InstrumentedRepository{next: next, backend: backend}
+}
+
+func (r *InstrumentedRepository) Unwrap() store.Repository {
+	return r.next
+}
+
+func (r *InstrumentedRepository) observe(op string, start time.Time, err error) {
+	status := "ok"
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		status = "not_found"
+	case err != nil:
+		status = "error"
+	}
+	RepositoryDuration.WithLabelValues(r.backend, op, status).Observe(time.Since(start).Seconds())
+}
+
+func (r *InstrumentedRepository) Load(ctx context.Context) (urls []store.StoredURL, err error) {
+	defer func(start time.Time) { r.observe("load", start, err) }(time.Now())
+	return r.next.Load(ctx)
+}
+
+func (r *InstrumentedRepository) Save(ctx context.Context, entry store.StoredURL) (err error) {
+	defer func(start time.Time) { r.observe("save", start, err) }(time.Now())
+	return r.next.Save(ctx, entry)
+}
+
+func (r *InstrumentedRepository) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { r.observe("ping", start, err) }(time.Now())
+	return r.next.Ping(ctx)
+}
+
+func (r *InstrumentedRepository) FindByShortID(ctx context.Context, id string) (url *store.StoredURL, err error) {
+	defer func(start time.Time) { r.observe("find_by_short_id", start, err) }(time.Now())
+	return r.next.FindByShortID(ctx, id)
+}
+
+func (r *InstrumentedRepository) FindByOriginalURL(ctx context.Context, userID, orig string) (url *store.StoredURL, err error) {
+	defer func(start time.Time) { r.observe("find_by_original_url", start, err) }(time.Now())
+	return r.next.FindByOriginalURL(ctx, userID, orig)
+}
+
+func (r *InstrumentedRepository) BatchSave(ctx context.Context, urls []store.StoredURL) (results []store.BatchResult, err error) {
+	defer func(start time.Time) { r.observe("batch_save", start, err) }(time.Now())
+	return r.next.BatchSave(ctx, urls)
+}
+
+func (r *InstrumentedRepository) GetURLsByUserID(ctx context.Context, userID string) (urls []store.StoredURL, err error) {
+	defer func(start time.Time) { r.observe("get_urls_by_user_id", start, err) }(time.Now())
+	return r.next.GetURLsByUserID(ctx, userID)
+}
+
+func (r *InstrumentedRepository) MarkDeleted(ctx context.Context, userID string, ids []string) (err error) {
+	defer func(start time.Time) { r.observe("mark_deleted", start, err) }(time.Now())
+	return r.next.MarkDeleted(ctx, userID, ids)
+}
+
+func (r *InstrumentedRepository) MarkDeletedBatch(ctx context.Context, reqs []store.DeleteRequest) (err error) {
+	defer func(start time.Time) { r.observe("mark_deleted_batch", start, err) }(time.Now())
+	return r.next.MarkDeletedBatch(ctx, reqs)
+}
+
+func (r *InstrumentedRepository) ExpireURLs(ctx context.Context, now time.Time) (n int64, err error) {
+	defer func(start time.Time) { r.observe("expire_urls", start, err) }(time.Now())
+	return r.next.ExpireURLs(ctx, now)
+}
+
+func (r *InstrumentedRepository) SaveClicks(ctx context.Context, clicks []store.ClickEvent) (err error) {
+	defer func(start time.Time) { r.observe("save_clicks", start, err) }(time.Now())
+	cs, ok := r.next.(store.ClickStore)
+	if !ok {
+		return errClicksUnsupported
+	}
+	return cs.SaveClicks(ctx, clicks)
+}
+
+func (r *InstrumentedRepository) GetLinkStats(ctx context.Context, shortID string) (stats *store.LinkStats, err error) {
+	defer func(start time.Time) { r.observe("get_link_stats", start, err) }(time.Now())
+	cs, ok := r.next.(store.ClickStore)
+	if !ok {
+		return nil, errClicksUnsupported
+	}
+	return cs.GetLinkStats(ctx, shortID)
+}
+
+func (r *InstrumentedRepository) LeaseIDRange(ctx context.Context, size uint64) (start uint64, err error) {
+	defer func(start time.Time) { r.observe("lease_id_range", start, err) }(time.Now())
+	leaser, ok := r.next.(store.IDRangeLeaser)
+	if !ok {
+		return 0, errRangesUnsupported
+	}
+	return leaser.LeaseIDRange(ctx, size)
+}
+
+func (r *InstrumentedRepository) SaveAPIKey(ctx context.Context, key store.APIKey) (err error) {
+	defer func(start time.Time) { r.observe("save_api_key", start, err) }(time.Now())
+	ks, ok := r.next.(store.APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.SaveAPIKey(ctx, key)
+}
+
+func (r *InstrumentedRepository) FindAPIKeyByHash(ctx context.Context, hash string) (key *store.APIKey, err error) {
+	defer func(start time.Time) { r.observe("find_api_key_by_hash", start, err) }(time.Now())
+	ks, ok := r.next.(store.APIKeyStore)
+	if !ok {
+		return nil, errKeysUnsupported
+	}
+	return ks.FindAPIKeyByHash(ctx, hash)
+}
+
+func (r *InstrumentedRepository) GetAPIKeysByUserID(ctx context.Context, userID string) (keys []store.APIKey, err error) {
+	defer func(start time.Time) { r.observe("get_api_keys_by_user_id", start, err) }(time.Now())
+	ks, ok := r.next.(store.APIKeyStore)
+	if !ok {
+		return nil, errKeysUnsupported
+	}
+	return ks.GetAPIKeysByUserID(ctx, userID)
+}
+
+func (r *InstrumentedRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) (err error) {
+	defer func(start time.Time) { r.observe("revoke_api_key", start, err) }(time.Now())
+	ks, ok := r.next.(store.APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.RevokeAPIKey(ctx, userID, id, at)
+}
+
+func (r *InstrumentedRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	defer func(start time.Time) { r.observe("touch_api_key", start, err) }(time.Now())
+	ks, ok := r.next.(store.APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.TouchAPIKey(ctx, id, at)
+}
+
+func (r *InstrumentedRepository) ListURLsAfter(ctx context.Context, after string, limit int) (urls []store.StoredURL, err error) {
+	defer func(start time.Time) { r.observe("list_urls_after", start, err) }(time.Now())
+	pager, ok := r.next.(store.URLPager)
+	if !ok {
+		return nil, errPagingUnsupported
+	}
+	return pager.ListURLsAfter(ctx, after, limit)
+}
+
+func (r *InstrumentedRepository) CountURLs(ctx context.Context) (n int64, err error) {
+	defer func(start time.Time) { r.observe("count_urls", start, err) }(time.Now())
+	counter, ok := r.next.(store.URLCounter)
+	if !ok {
+		return 0, errCountUnsupported
+	}
+	return counter.CountURLs(ctx)
+}
+
+func (r *InstrumentedRepository) Close() error {
+	if closer, ok := r.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
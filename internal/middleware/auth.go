@@ -2,28 +2,103 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"cuturl/internal/auth"
+	"cuturl/internal/service"
 )
 
 type CtxKey string
 
-const UserIDKey CtxKey = "userID"
+const (
+	UserIDKey  CtxKey = "userID"
+	newUserKey CtxKey = "newUser"
+	apiKeyKey  CtxKey = "apiKey"
+)
+
+// IsNewUser reports whether AuthMiddleware issued the request's user ID
+// itself because the request carried no valid credentials.
+func IsNewUser(ctx context.Context) bool {
+	isNew, _ := ctx.Value(newUserKey).(bool)
+	return isNew
+}
+
+// IsAPIKeyAuth reports whether the request was authenticated with an API key
+// rather than the auth cookie.
+func IsAPIKeyAuth(ctx context.Context) bool {
+	viaKey, _ := ctx.Value(apiKeyKey).(bool)
+	return viaKey
+}
+
+// APIKeyAuthenticator resolves an API key to the user it belongs to, failing
+// with service.ErrInvalidAPIKey for keys that can't be used.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, secret string) (string, error)
+}
 
+// AuthMiddleware identifies users by the auth cookie alone.
 func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID, err := auth.GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			newUserID := auth.GenerateToken()
-			auth.SetAuthCookie(w, newUserID)
+	return NewAuthMiddleware(nil)(next)
+}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, newUserID)
+// NewAuthMiddleware also accepts "Authorization: Bearer <API key>" when keys
+// is set. A request that presents a key must present a valid one: it gets
+// 401 rather than falling back to the cookie or a fresh user, so scripts
+// notice a revoked key.
+func NewAuthMiddleware(keys APIKeyAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := bearerToken(r)
+			if !ok || keys == nil {
+				cookieAuth(next, w, r)
+				return
+			}
+			userID, err := keys.AuthenticateAPIKey(r.Context(), secret)
+			if errors.Is(err, service.ErrInvalidAPIKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, apiKeyKey, true)
 			next.ServeHTTP(w, r.WithContext(ctx))
-			return
-		}
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// cookieAuth renews tokens that are getting old or were signed with a
+// retired key, so active users never hit the expiry.
+func cookieAuth(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.ClaimsFromRequest(r)
+	userID := claims.UserID
+	if err != nil || userID == "" {
+		newUserID := auth.GenerateToken()
+		auth.SetAuthCookie(w, newUserID)
 
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx := context.WithValue(r.Context(), UserIDKey, newUserID)
+		ctx = context.WithValue(ctx, newUserKey, true)
 		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		return
+	}
+
+	if claims.NeedsRenewal() {
+		auth.SetAuthCookie(w, userID)
+	}
+	ctx := context.WithValue(r.Context(), UserIDKey, userID)
+	next.ServeHTTP(w, r.WithContext(ctx))
 }
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cuturl/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddlewareRenewsTokens(t *testing.T) {
+	oldKey := auth.Key{ID: "old", Secret: []byte("old-secret")}
+	ring, err := auth.NewKeyring(oldKey)
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+	oldToken := auth.IssueToken("user-1")
+
+	ring, err = auth.NewKeyring(auth.Key{ID: "new", Secret: []byte("new-secret")}, oldKey)
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+
+	var seen string
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(UserIDKey).(string)
+	}))
+	do := func(token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// Signed with the retired key: same user, new token.
+	resp := do(oldToken)
+	assert.Equal(t, "user-1", seen)
+	require.Len(t, resp.Cookies(), 1)
+	claims, err := auth.ParseToken(resp.Cookies()[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "new", claims.KeyID)
+
+	// A fresh token is left alone.
+	resp = do(resp.Cookies()[0].Value)
+	assert.Equal(t, "user-1", seen)
+	assert.Empty(t, resp.Cookies())
+
+	// A forged one gets a new user.
+	resp = do("v2|new|user-1|0|9999999999|forged")
+	assert.NotEqual(t, "user-1", seen)
+	assert.Len(t, resp.Cookies(), 1)
+}
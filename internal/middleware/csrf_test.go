@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cuturl/internal/auth"
+	"cuturl/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticKeys map[string]string
+
+func (k staticKeys) AuthenticateAPIKey(_ context.Context, secret string) (string, error) {
+	if userID, ok := k[secret]; ok {
+		return userID, nil
+	}
+	return "", service.ErrInvalidAPIKey
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	ring, err := auth.NewKeyring(auth.Key{ID: "test", Secret: []byte("test-secret")})
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+	token := auth.IssueToken("user-1")
+
+	chain := NewAuthMiddleware(staticKeys{"cuk_valid": "user-2"})(
+		CSRFMiddleware([]string{"https://app.example.com/"})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})))
+
+	tests := []struct {
+		name    string
+		method  string
+		cookie  bool
+		headers map[string]string
+		want    int
+	}{
+		{name: "same host origin", method: http.MethodPost, cookie: true, headers: map[string]string{"Origin": "http://sho.rt"}, want: http.StatusNoContent},
+		{name: "trusted origin", method: http.MethodDelete, cookie: true, headers: map[string]string{"Origin": "https://APP.example.com"}, want: http.StatusNoContent},
+		{name: "foreign origin", method: http.MethodPost, cookie: true, headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, cookie: true, headers: map[string]string{"Origin": "null"}, want: http.StatusForbidden},
+		{name: "foreign referer", method: http.MethodPost, cookie: true, headers: map[string]string{"Referer": "https://evil.example/page"}, want: http.StatusForbidden},
+		{name: "same host referer", method: http.MethodPost, cookie: true, headers: map[string]string{"Referer": "http://sho.rt/form"}, want: http.StatusNoContent},
+		{name: "cross-site fetch", method: http.MethodPost, cookie: true, headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusForbidden},
+		{name: "non-browser client", method: http.MethodPost, cookie: true, want: http.StatusNoContent},
+		{name: "safe method", method: http.MethodGet, cookie: true, headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusNoContent},
+		{name: "no cookie", method: http.MethodPost, headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusNoContent},
+		{name: "api key", method: http.MethodPost, cookie: true, headers: map[string]string{"Origin": "https://evil.example", "Authorization": "Bearer cuk_valid"}, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://sho.rt/api/shorten", nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: auth.DefaultCookieName, Value: token})
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"cuturl/internal/metrics"
 )
 
 type gzipWriter struct {
@@ -41,6 +43,7 @@ func GzipCompressMiddleware(next http.Handler) http.Handler {
 		defer gz.Close()
 
 		w.Header().Set("Content-Encoding", "gzip")
+		metrics.GzipResponses.Inc()
 
 		gzrw := gzipWriter{ResponseWriter: w, Writer: gz}
 		next.ServeHTTP(gzrw, r)
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"cuturl/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		mrw := &loggingResponseWriter{
+			ResponseWriter: w,
+			responseData:   &responseData{statusCode: defaultStatusCode},
+		}
+		next.ServeHTTP(mrw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		code := strconv.Itoa(mrw.responseData.statusCode)
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, code).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
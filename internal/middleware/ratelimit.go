@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cuturl/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// RateLimit is a token bucket: it holds up to Burst requests and refills at
+// Rate requests per second. The zero value disables limiting.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// RateLimitResult is the state of a bucket after one request was counted.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed; zero
+	// when this one was.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps the buckets. MemoryRateLimitStore serves a single
+// instance; several instances need a shared implementation to enforce one
+// budget between them.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely.
+	full time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Buckets that have
+// refilled completely are dropped, since a fresh one behaves the same.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+const rateLimitSweepInterval = time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// TrustedProxies are the networks of load balancers and reverse proxies in
+// front of the service. A request from one of them is charged to the client
+// it names in X-Forwarded-For rather than to the proxy.
+type TrustedProxies []netip.Prefix
+
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP walks X-Forwarded-For from the right, past trusted proxies, to the
+// first address a client could have forged no further than its own.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !p.trusts(addr.Unmap()) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !p.trusts(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// RateLimitMiddleware charges each request to a bucket for class, keyed by
+// the user when the request carried valid credentials and by client IP
+// otherwise; an identity AuthMiddleware has just issued doesn't count, or
+// dropping the cookie would reset the budget. Rejected requests get 429 with
+// Retry-After. Store failures are logged and let the request through.
+// Must run after AuthMiddleware.
+func RateLimitMiddleware(store RateLimitStore, class string, limit RateLimit, proxies TrustedProxies, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := store.Take(r.Context(), class+":"+rateLimitKey(r, proxies), limit, time.Now())
+			if err != nil {
+				logger.Errorw("rate limit store failed", "class", class, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+			if !res.Allowed {
+				metrics.RateLimited.WithLabelValues(class).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimitKey(r *http.Request, proxies TrustedProxies) string {
+	if userID, ok := r.Context().Value(UserIDKey).(string); ok && userID != "" && !IsNewUser(r.Context()) {
+		return "user:" + userID
+	}
+	return "ip:" + proxies.ClientIP(r)
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"cuturl/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryRateLimitStore()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	res, err := s.Take(ctx, "k", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 1, Reset: time.Second}, res)
+	res, _ = s.Take(ctx, "k", limit, now)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = s.Take(ctx, "k", limit, now.Add(500*time.Millisecond))
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	res, _ = s.Take(ctx, "other", limit, now)
+	assert.True(t, res.Allowed, "buckets are per key")
+
+	res, _ = s.Take(ctx, "k", limit, now.Add(time.Second))
+	assert.True(t, res.Allowed)
+
+	// Full buckets are swept, others are kept.
+	s.Take(ctx, "slow", RateLimit{Rate: 0.0001, Burst: 1}, now)
+	s.Take(ctx, "k", limit, now.Add(time.Hour))
+	assert.NotContains(t, s.buckets, "other")
+	assert.Contains(t, s.buckets, "slow")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ring, err := auth.NewKeyring(auth.Key{ID: "test", Secret: []byte("test-secret")})
+	require.NoError(t, err)
+	auth.Init(ring, auth.Options{})
+
+	proxies := TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := NewAuthMiddleware(staticKeys{"cuk_valid": "user-2"})(RateLimitMiddleware(NewMemoryRateLimitStore(), "create", RateLimit{Rate: 0.01, Burst: 1}, proxies, zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	doWith := func(remoteAddr string, cookies []*http.Cookie, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	do := func(remoteAddr string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		return doWith(remoteAddr, cookies, nil)
+	}
+
+	// Without credentials every request gets a new user, so the IP is what's
+	// limited.
+	first := do("192.0.2.1:1000", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "1", first.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", first.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "100", first.Header().Get("X-RateLimit-Reset"))
+
+	second := do("192.0.2.1:2000", nil)
+	assert.Equal(t, http.StatusTooManyRequests, second.Code)
+	assert.Equal(t, "100", second.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, do("192.0.2.2:1000", nil).Code)
+
+	// A returning user has a budget of their own, whatever their IP, and
+	// doesn't spend the IP's.
+	cookies := first.Result().Cookies()
+	require.NotEmpty(t, cookies)
+	assert.Equal(t, http.StatusOK, do("192.0.2.1:3000", cookies).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.3:1000", cookies).Code)
+	assert.Equal(t, http.StatusOK, do("192.0.2.3:2000", nil).Code)
+
+	// API keys have a budget of their own, whatever the IP.
+	bearer := http.Header{"Authorization": {"Bearer cuk_valid"}}
+	assert.Equal(t, http.StatusOK, doWith("192.0.2.1:4000", nil, bearer).Code)
+	assert.Equal(t, http.StatusTooManyRequests, doWith("192.0.2.5:1000", nil, bearer).Code)
+
+	// Behind a trusted proxy the forwarded client is charged, not the proxy.
+	forwarded := func(xff string) http.Header { return http.Header{"X-Forwarded-For": {xff}} }
+	assert.Equal(t, http.StatusOK, doWith("10.0.0.1:1000", nil, forwarded("198.51.100.1")).Code)
+	assert.Equal(t, http.StatusOK, doWith("10.0.0.1:1000", nil, forwarded("198.51.100.2, 10.0.0.2")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, doWith("10.0.0.1:1000", nil, forwarded("198.51.100.1")).Code)
+	// An untrusted peer can't pick its bucket.
+	assert.Equal(t, http.StatusTooManyRequests, doWith("192.0.2.1:5000", nil, forwarded("198.51.100.9")).Code)
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies := TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}
+	ip := func(remoteAddr string, xff ...string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, v := range xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		return proxies.ClientIP(req)
+	}
+	assert.Equal(t, "192.0.2.1", ip("192.0.2.1:1000", "198.51.100.1"))
+	assert.Equal(t, "10.0.0.1", ip("10.0.0.1:1000"))
+	assert.Equal(t, "198.51.100.1", ip("10.0.0.1:1000", "203.0.113.7, 198.51.100.1, 10.0.0.2"))
+	assert.Equal(t, "198.51.100.1", ip("10.0.0.1:1000", "203.0.113.7", "198.51.100.1"))
+	assert.Equal(t, "10.0.0.2", ip("10.0.0.1:1000", "garbage, 10.0.0.2"))
+}
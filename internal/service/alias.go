@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidAlias  = errors.New("alias may contain only letters, digits, '-' and '_' (3-64 chars)")
+	ErrReservedAlias = errors.New("alias is reserved")
+	ErrAliasTaken    = errors.New("alias already taken")
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+var reservedAliases = map[string]struct{}{
+	"ping":    {},
+	"api":     {},
+	"metrics": {},
+}
+
+func ValidateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return ErrInvalidAlias
+	}
+	if isReserved(alias) {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+// isReserved reports whether id would shadow one of the service's own
+// routes. Generated IDs are checked too, since short lengths can produce one.
+func isReserved(id string) bool {
+	_, ok := reservedAliases[strings.ToLower(id)]
+	return ok
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"cuturl/internal/store"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidAPIKey covers unknown, expired and revoked keys alike.
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrInvalidKeyName     = errors.New("API key name must be 1 to 100 characters")
+	ErrAPIKeysUnsupported = errors.New("repository does not store API keys")
+)
+
+// apiKeyPrefix marks our secrets, so they are easy to recognise in configs
+// and for secret scanners.
+const apiKeyPrefix = "cuk_"
+
+// apiKeyTouchInterval limits how often a key's last-used time is written:
+// a busy script would otherwise cost a write per request.
+const apiKeyTouchInterval = time.Minute
+
+const maxKeyNameLen = 100
+
+func (s *URLService) apiKeys() (store.APIKeyStore, error) {
+	ks, ok := store.AsAPIKeyStore(s.repo)
+	if !ok {
+		return nil, ErrAPIKeysUnsupported
+	}
+	return ks, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateAPIKey issues a key for userID and returns its secret, which is not
+// stored and can't be recovered later.
+func (s *URLService) CreateAPIKey(ctx context.Context, userID, name string, expiresAt *time.Time) (string, *store.APIKey, error) {
+	ks, err := s.apiKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxKeyNameLen {
+		return "", nil, ErrInvalidKeyName
+	}
+
+	id, err := randomToken(9)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	secret = apiKeyPrefix + secret
+
+	key := store.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashAPIKey(secret),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt,
+	}
+	if err := ks.SaveAPIKey(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return secret, &key, nil
+}
+
+func (s *URLService) ListAPIKeys(ctx context.Context, userID string) ([]store.APIKey, error) {
+	ks, err := s.apiKeys()
+	if err != nil {
+		return nil, err
+	}
+	return ks.GetAPIKeysByUserID(ctx, userID)
+}
+
+// RevokeAPIKey returns store.ErrNotFound unless userID owns the key.
+func (s *URLService) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	ks, err := s.apiKeys()
+	if err != nil {
+		return err
+	}
+	return ks.RevokeAPIKey(ctx, userID, id, time.Now().UTC())
+}
+
+// AuthenticateAPIKey returns the user a usable key belongs to and records
+// that it was used.
+func (s *URLService) AuthenticateAPIKey(ctx context.Context, secret string) (string, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return "", ErrInvalidAPIKey
+	}
+	ks, err := s.apiKeys()
+	if err != nil {
+		return "", err
+	}
+	key, err := ks.FindAPIKeyByHash(ctx, hashAPIKey(secret))
+	if errors.Is(err, store.ErrNotFound) {
+		return "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	if !key.Usable(now) {
+		return "", ErrInvalidAPIKey
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := ks.TouchAPIKey(ctx, key.ID, now); err != nil {
+			s.logger.Warnw("failed to record API key use", "key", key.ID, "error", err)
+		}
+	}
+	return key.UserID, nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{})
+	defer svc.Close()
+
+	_, _, err := svc.CreateAPIKey(ctx, "u1", "  ", nil)
+	assert.ErrorIs(t, err, ErrInvalidKeyName)
+	_, _, err = svc.CreateAPIKey(ctx, "u1", strings.Repeat("x", 101), nil)
+	assert.ErrorIs(t, err, ErrInvalidKeyName)
+
+	secret, key, err := svc.CreateAPIKey(ctx, "u1", " ci ", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, apiKeyPrefix))
+	assert.Equal(t, "ci", key.Name)
+	assert.NotContains(t, key.Hash, secret, "the secret is not stored")
+
+	userID, err := svc.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, "u1", userID)
+
+	// The first use is recorded; the next ones within a minute aren't.
+	stored, err := repo.FindAPIKeyByHash(ctx, key.Hash)
+	require.NoError(t, err)
+	require.NotNil(t, stored.LastUsedAt)
+	firstUse := *stored.LastUsedAt
+	_, err = svc.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	stored, _ = repo.FindAPIKeyByHash(ctx, key.Hash)
+	assert.True(t, firstUse.Equal(*stored.LastUsedAt))
+
+	for _, bad := range []string{"", "cuk_unknown", secret[len(apiKeyPrefix):]} {
+		_, err = svc.AuthenticateAPIKey(ctx, bad)
+		assert.ErrorIs(t, err, ErrInvalidAPIKey, bad)
+	}
+
+	assert.ErrorIs(t, svc.RevokeAPIKey(ctx, "u2", key.ID), store.ErrNotFound)
+	require.NoError(t, svc.RevokeAPIKey(ctx, "u1", key.ID))
+	_, err = svc.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := svc.CreateAPIKey(ctx, "u1", "old", &past)
+	require.NoError(t, err)
+	_, err = svc.AuthenticateAPIKey(ctx, expired)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	keys, err := svc.ListAPIKeys(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/idna"
+)
+
+// BlocklistChecker blocks destinations listed in a text file, one rule per
+// line:
+//
+//	# comment
+//	phish.example     the domain and all its subdomains
+//	/login\.php\?acct=/   a regular expression matched against the whole URL
+type BlocklistChecker struct {
+	file  watchedFile
+	rules atomic.Pointer[blocklist]
+}
+
+type blocklist struct {
+	domains map[string]struct{}
+	regexps []*regexp.Regexp
+}
+
+func NewBlocklistChecker(path string) (*BlocklistChecker, error) {
+	b := &BlocklistChecker{file: watchedFile{path: path}}
+	if err := b.file.load(true, b.parse); err != nil {
+		return nil, fmt.Errorf("blocklist %s: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *BlocklistChecker) ReloadIfChanged() error {
+	if err := b.file.load(false, b.parse); err != nil {
+		return fmt.Errorf("blocklist %s: %w", b.file.path, err)
+	}
+	return nil
+}
+
+func (b *BlocklistChecker) parse(data []byte) error {
+	list := &blocklist{domains: make(map[string]struct{})}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			re, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			list.regexps = append(list.regexps, re)
+		default:
+			domain := strings.TrimSuffix(strings.TrimPrefix(line, "*."), ".")
+			domain, err := idna.Lookup.ToASCII(domain)
+			if err != nil || domain == "" {
+				return fmt.Errorf("line %d: invalid domain %q", n, line)
+			}
+			list.domains[domain] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	b.rules.Store(list)
+	return nil
+}
+
+func (b *BlocklistChecker) Check(ctx context.Context, originalURL string) (SafetyVerdict, error) {
+	list := b.rules.Load()
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return SafetyVerdict{}, err
+	}
+
+	// Walk up from the host itself: a.b.phish.example, b.phish.example, ...
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for h := host; h != ""; {
+		if _, ok := list.domains[h]; ok {
+			return SafetyVerdict{Blocked: true, Reason: fmt.Sprintf("domain %s is blocklisted", h)}, nil
+		}
+		_, parent, found := strings.Cut(h, ".")
+		if !found {
+			break
+		}
+		h = parent
+	}
+
+	for _, re := range list.regexps {
+		if re.MatchString(originalURL) {
+			return SafetyVerdict{Blocked: true, Reason: "URL matches a blocklist rule"}, nil
+		}
+	}
+	return SafetyVerdict{}, nil
+}
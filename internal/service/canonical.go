@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidURL matches every *URLError.
+var ErrInvalidURL = errors.New("invalid URL")
+
+// Machine-readable URLError codes, returned to API clients as is.
+const (
+	URLErrEmpty            = "empty"
+	URLErrMalformed        = "malformed"
+	URLErrNotAbsolute      = "not_absolute"
+	URLErrSchemeNotAllowed = "scheme_not_allowed"
+	URLErrInvalidHost      = "invalid_host"
+	URLErrInvalidPort      = "invalid_port"
+	URLErrShortLink        = "short_link"
+)
+
+// URLError explains why an original URL was rejected.
+type URLError struct {
+	Code    string
+	Message string
+}
+
+func (e *URLError) Error() string {
+	return "invalid URL: " + e.Message
+}
+
+func (e *URLError) Is(target error) bool {
+	return target == ErrInvalidURL || (target == ErrEmptyURL && e.Code == URLErrEmpty)
+}
+
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// DefaultTrackingParams are dropped from the query when StripTrackingParams
+// is on. Entries ending in '_' match any parameter with that prefix.
+var DefaultTrackingParams = []string{
+	"utm_", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid",
+	"yclid", "mc_cid", "mc_eid", "igshid", "_ga", "_gl",
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// URLPolicy validates original URLs and rewrites them into the canonical form
+// that is stored and deduplicated on.
+type URLPolicy struct {
+	AllowedSchemes      []string
+	StripTrackingParams bool
+	TrackingParams      []string
+}
+
+func (p URLPolicy) withDefaults() URLPolicy {
+	if len(p.AllowedSchemes) == 0 {
+		p.AllowedSchemes = DefaultAllowedSchemes
+	}
+	if len(p.TrackingParams) == 0 {
+		p.TrackingParams = DefaultTrackingParams
+	}
+	return p
+}
+
+// Canonicalize lowercases the scheme and host, converts IDN hosts to
+// punycode, drops the scheme's default port and, if enabled, removes tracking
+// parameters. The path is kept as is. Errors are *URLError.
+func (p URLPolicy) Canonicalize(raw string) (string, error) {
+	p = p.withDefaults()
+
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", &URLError{Code: URLErrEmpty, Message: ErrEmptyURL.Error()}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", &URLError{Code: URLErrMalformed, Message: "cannot parse URL"}
+	}
+	if u.Scheme == "" {
+		return "", &URLError{Code: URLErrNotAbsolute, Message: "URL must be absolute, with a scheme and a host"}
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !p.allowsScheme(u.Scheme) {
+		return "", &URLError{
+			Code:    URLErrSchemeNotAllowed,
+			Message: fmt.Sprintf("scheme %q is not allowed; use %s", u.Scheme, strings.Join(p.AllowedSchemes, " or ")),
+		}
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", &URLError{Code: URLErrNotAbsolute, Message: "URL must be absolute, with a scheme and a host"}
+	}
+
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", &URLError{Code: URLErrInvalidHost, Message: fmt.Sprintf("invalid host %q", u.Hostname())}
+	}
+	port := u.Port()
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", &URLError{Code: URLErrInvalidPort, Message: fmt.Sprintf("invalid port %q", port)}
+		}
+	}
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if p.StripTrackingParams && u.RawQuery != "" {
+		u.RawQuery = p.stripTracking(u.RawQuery)
+		u.ForceQuery = false
+	}
+	return u.String(), nil
+}
+
+func (p URLPolicy) allowsScheme(scheme string) bool {
+	for _, s := range p.AllowedSchemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("empty host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+// stripTracking filters the raw query piece by piece, so the order and
+// encoding of the parameters that remain are left alone.
+func (p URLPolicy) stripTracking(rawQuery string) string {
+	pieces := strings.Split(rawQuery, "&")
+	kept := pieces[:0]
+	for _, piece := range pieces {
+		key, _, _ := strings.Cut(piece, "=")
+		if name, err := url.QueryUnescape(key); err == nil && p.isTracking(strings.ToLower(name)) {
+			continue
+		}
+		kept = append(kept, piece)
+	}
+	return strings.Join(kept, "&")
+}
+
+func (p URLPolicy) isTracking(name string) bool {
+	for _, t := range p.TrackingParams {
+		if name == t || (strings.HasSuffix(t, "_") && strings.HasPrefix(name, t)) {
+			return true
+		}
+	}
+	return false
+}
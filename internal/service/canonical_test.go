@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   URLPolicy
+		raw      string
+		want     string
+		wantCode string
+	}{
+		{name: "unchanged", raw: "https://example.com/a?b=c#d", want: "https://example.com/a?b=c#d"},
+		{name: "trims space", raw: "  https://example.com  ", want: "https://example.com"},
+		{name: "lowercases scheme and host", raw: "HTTPS://Example.COM/Path", want: "https://example.com/Path"},
+		{name: "default http port", raw: "http://example.com:80/", want: "http://example.com/"},
+		{name: "default https port", raw: "https://example.com:443/", want: "https://example.com/"},
+		{name: "other port kept", raw: "https://example.com:8443/", want: "https://example.com:8443/"},
+		{name: "idn host", raw: "https://Bücher.example/", want: "https://xn--bcher-kva.example/"},
+		{name: "ipv6 host", raw: "http://[2001:DB8::1]:80/", want: "http://[2001:db8::1]/"},
+		{name: "tracking kept by default", raw: "https://example.com/?utm_source=x&id=1", want: "https://example.com/?utm_source=x&id=1"},
+		{
+			name:   "tracking stripped",
+			policy: URLPolicy{StripTrackingParams: true},
+			raw:    "https://example.com/?utm_source=x&id=1&fbclid=y&UTM_Medium=z&b=%20",
+			want:   "https://example.com/?id=1&b=%20",
+		},
+		{
+			name:   "only tracking",
+			policy: URLPolicy{StripTrackingParams: true},
+			raw:    "https://example.com/?gclid=1",
+			want:   "https://example.com/",
+		},
+		{name: "custom scheme allowed", policy: URLPolicy{AllowedSchemes: []string{"ftp"}}, raw: "FTP://files.example/x", want: "ftp://files.example/x"},
+
+		{name: "empty", raw: "  ", wantCode: URLErrEmpty},
+		{name: "not a url", raw: "not a url", wantCode: URLErrNotAbsolute},
+		{name: "relative path", raw: "/path/to", wantCode: URLErrNotAbsolute},
+		{name: "javascript", raw: "javascript:alert(1)", wantCode: URLErrSchemeNotAllowed},
+		{name: "mailto", raw: "mailto:a@example.com", wantCode: URLErrSchemeNotAllowed},
+		{name: "http excluded", policy: URLPolicy{AllowedSchemes: []string{"https"}}, raw: "http://example.com", wantCode: URLErrSchemeNotAllowed},
+		{name: "no host", raw: "https:///path", wantCode: URLErrNotAbsolute},
+		{name: "opaque", raw: "http:example.com", wantCode: URLErrNotAbsolute},
+		{name: "malformed", raw: "http://exa mple.com/%zz", wantCode: URLErrMalformed},
+		{name: "bad host", raw: "http://-bad-.xn--a/", wantCode: URLErrInvalidHost},
+		{name: "bad port", raw: "http://example.com:99999/", wantCode: URLErrInvalidPort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Canonicalize(tt.raw)
+			if tt.wantCode == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+				return
+			}
+			var urlErr *URLError
+			require.True(t, errors.As(err, &urlErr), "want *URLError, got %v", err)
+			assert.Equal(t, tt.wantCode, urlErr.Code)
+			assert.ErrorIs(t, err, ErrInvalidURL)
+		})
+	}
+}
+
+func TestShortenDedupsCanonicalForm(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{URLPolicy: URLPolicy{StripTrackingParams: true}})
+	defer svc.Close()
+
+	first, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "HTTPS://Example.com:443/a?utm_source=mail", UserID: "u1"})
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.Equal(t, "https://example.com/a", first.OriginalURL)
+
+	again, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com/a", UserID: "u1"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ShortURL, again.ShortURL)
+
+	_, _, err = svc.Shorten(ctx, ShortenInput{OriginalURL: "javascript:alert(1)", UserID: "u1"})
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{
+		{OriginalURL: "https://EXAMPLE.com/a", UserID: "u1"},
+		{OriginalURL: "not a url", UserID: "u1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BatchItemExists, results[0].Status)
+	assert.Equal(t, BatchItemInvalid, results[1].Status)
+	assert.Equal(t, URLErrNotAbsolute, results[1].Code)
+}
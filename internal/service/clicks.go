@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	clickBufferSize    = 4096
+	clickBatchSize     = 256
+	clickFlushInterval = time.Second
+)
+
+var (
+	ErrLinkNotFound      = errors.New("link not found")
+	ErrNotOwner          = errors.New("link belongs to another user")
+	ErrStatsNotAvailable = errors.New("click statistics are not supported by the storage")
+)
+
+// ClickTracker buffers redirect events and writes them to the click store in
+// batches from a single goroutine, so recording never blocks a redirect.
+type ClickTracker struct {
+	store  store.ClickStore
+	logger *zap.SugaredLogger
+	events chan store.ClickEvent
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	// ctx bounds every write; Shutdown cancels it when it runs out of time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewClickTracker(cs store.ClickStore, logger *zap.SugaredLogger) *ClickTracker {
+	t := &ClickTracker{
+		store:  cs,
+		logger: logger,
+		events: make(chan store.ClickEvent, clickBufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.run()
+	return t
+}
+
+func (t *ClickTracker) Track(e store.ClickEvent) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+
+	select {
+	case t.events <- e:
+	default:
+		t.logger.Warnf("click buffer full, dropping click for %q", e.ShortURL)
+	}
+}
+
+// Close stops accepting clicks and flushes whatever is still buffered.
+func (t *ClickTracker) Close() {
+	t.Shutdown(context.Background())
+}
+
+// Shutdown is Close bounded by ctx: if ctx is done before the buffer is
+// flushed, the write in progress is cancelled and ctx's error returned.
+func (t *ClickTracker) Shutdown(ctx context.Context) error {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+	select {
+	case <-t.done:
+		t.cancel()
+		return nil
+	case <-ctx.Done():
+		t.cancel()
+		return ctx.Err()
+	}
+}
+
+func (t *ClickTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]store.ClickEvent, 0, clickBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(t.ctx, 10*time.Second)
+		defer cancel()
+		if err := t.store.SaveClicks(ctx, batch); err != nil {
+			t.logger.Errorf("failed to save %d clicks: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e := <-t.events:
+			batch = append(batch, e)
+			if len(batch) >= clickBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case e := <-t.events:
+					batch = append(batch, e)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// IPBucket reduces a client address to its /24 (IPv4) or /48 (IPv6) network
+// so that raw addresses are never persisted.
+func IPBucket(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestIPBucket(t *testing.T) {
+	assert.Equal(t, "203.0.113.0/24", IPBucket("203.0.113.57:5123"))
+	assert.Equal(t, "2001:db8:abcd::/48", IPBucket("[2001:db8:abcd:12::1]:443"))
+	assert.Equal(t, "", IPBucket("not-an-ip"))
+}
+
+func TestGetLinkStats(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{})
+	ctx := context.Background()
+
+	require.NoError(t, svc.SaveURL(ctx, store.StoredURL{UUID: "abc", ShortURL: "abc", OriginalURL: "https://example.com", UserID: "owner"}))
+
+	day1 := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	svc.RecordClick(store.ClickEvent{ShortURL: "abc", ClickedAt: day1, UserAgent: "curl", IPBucket: "10.0.0.0/24"})
+	svc.RecordClick(store.ClickEvent{ShortURL: "abc", ClickedAt: day1, UserAgent: "curl", IPBucket: "10.0.0.0/24"})
+	svc.RecordClick(store.ClickEvent{ShortURL: "abc", ClickedAt: day2, UserAgent: "firefox", IPBucket: "10.0.0.0/24"})
+	svc.Close()
+
+	stats, err := svc.GetLinkStats(ctx, "owner", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.TotalClicks)
+	assert.Equal(t, int64(2), stats.UniqueVisitors)
+	assert.Equal(t, []store.DailyClicks{{Date: "2025-03-01", Clicks: 2}, {Date: "2025-03-02", Clicks: 1}}, stats.Daily)
+
+	_, err = svc.GetLinkStats(ctx, "intruder", "abc")
+	assert.ErrorIs(t, err, ErrNotOwner)
+
+	_, err = svc.GetLinkStats(ctx, "owner", "missing")
+	assert.ErrorIs(t, err, ErrLinkNotFound)
+}
+
+type stuckClicks struct {
+	*store.InMemoryRepository
+}
+
+func (r *stuckClicks) SaveClicks(ctx context.Context, clicks []store.ClickEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestClickTrackerShutdownIsBoundedByContext(t *testing.T) {
+	tracker := NewClickTracker(&stuckClicks{store.NewInMemoryRepository()}, zap.NewNop().Sugar())
+	tracker.Track(store.ClickEvent{ShortURL: "abc", ClickedAt: time.Now()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, tracker.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const deleteTimeout = 30 * time.Second
+
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// deleteBatcher funnels every DELETE request through one goroutine, merges
+// them per user and writes them with a single MarkDeletedBatch call once
+// batchSize IDs are pending or flushInterval has passed. Producers block
+// while the queue is full.
+type deleteBatcher struct {
+	repo          store.Repository
+	logger        *zap.SugaredLogger
+	queue         chan store.DeleteRequest
+	batchSize     int
+	flushInterval time.Duration
+
+	// mu guards closed, so that no sender registers once close has started.
+	// Senders block on the queue without it and give up when closing is
+	// closed; the queue itself is closed once the last of them has left.
+	mu      sync.Mutex
+	closed  bool
+	closing chan struct{}
+	senders sync.WaitGroup
+	done    chan struct{}
+	// ctx bounds every write; cancelling it makes the remaining flushes fail
+	// fast when shutdown runs out of time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDeleteBatcher(repo store.Repository, logger *zap.SugaredLogger, queueSize, batchSize int, flushInterval time.Duration) *deleteBatcher {
+	b := &deleteBatcher{
+		repo:          repo,
+		logger:        logger,
+		queue:         make(chan store.DeleteRequest, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.run()
+	return b
+}
+
+func (b *deleteBatcher) submit(ctx context.Context, req store.DeleteRequest) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrShuttingDown
+	}
+	b.senders.Add(1)
+	b.mu.Unlock()
+	defer b.senders.Done()
+
+	select {
+	case b.queue <- req:
+		return nil
+	case <-b.closing:
+		return ErrShuttingDown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *deleteBatcher) depth() int {
+	return len(b.queue)
+}
+
+// close stops accepting requests and writes the pending ones. If ctx is done
+// first it returns at once and the writes still in progress are cancelled.
+func (b *deleteBatcher) close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closing)
+		go func() {
+			b.senders.Wait()
+			close(b.queue)
+		}()
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+func (b *deleteBatcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]map[string]struct{})
+	count := 0
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		for _, reqs := range chunkDeletes(pending, b.batchSize) {
+			b.write(reqs)
+		}
+		pending = make(map[string]map[string]struct{})
+		count = 0
+	}
+
+	for {
+		select {
+		case req, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			ids := pending[req.UserID]
+			if ids == nil {
+				ids = make(map[string]struct{}, len(req.ShortIDs))
+				pending[req.UserID] = ids
+			}
+			for _, id := range req.ShortIDs {
+				if _, dup := ids[id]; !dup {
+					ids[id] = struct{}{}
+					count++
+				}
+			}
+			if count >= b.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write marks one chunk deleted. A failed chunk is logged and dropped
+// without affecting the others.
+func (b *deleteBatcher) write(reqs []store.DeleteRequest) {
+	ctx, cancel := context.WithTimeout(b.ctx, deleteTimeout)
+	defer cancel()
+	if err := b.repo.MarkDeletedBatch(ctx, reqs); err != nil {
+		n := 0
+		for _, req := range reqs {
+			n += len(req.ShortIDs)
+		}
+		b.logger.Errorf("failed to mark %d urls deleted: %v", n, err)
+	}
+}
+
+// chunkDeletes splits pending deletions into batches of at most size IDs, so
+// one huge request can't exceed the database's limit on bound parameters.
+// A user's IDs may be spread over several batches.
+func chunkDeletes(pending map[string]map[string]struct{}, size int) [][]store.DeleteRequest {
+	var chunks [][]store.DeleteRequest
+	var chunk []store.DeleteRequest
+	n := 0
+	for userID, ids := range pending {
+		req := store.DeleteRequest{UserID: userID}
+		for id := range ids {
+			if n == size {
+				if len(req.ShortIDs) > 0 {
+					chunk = append(chunk, req)
+				}
+				chunks = append(chunks, chunk)
+				chunk, n = nil, 0
+				req = store.DeleteRequest{UserID: userID}
+			}
+			req.ShortIDs = append(req.ShortIDs, id)
+			n++
+		}
+		chunk = append(chunk, req)
+	}
+	if n > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCloseDrainsDeletions(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{})
+	ctx := context.Background()
+
+	require.NoError(t, svc.SaveURL(ctx, store.StoredURL{UUID: "abc", ShortURL: "abc", OriginalURL: "https://example.com", UserID: "u1"}))
+	require.NoError(t, svc.MarkDeletedAsync(ctx, "u1", []string{"abc"}))
+
+	svc.Close()
+
+	entry, err := repo.FindByShortID(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+	assert.ErrorIs(t, svc.MarkDeletedAsync(ctx, "u1", []string{"abc"}), ErrShuttingDown)
+}
+
+type countingRepo struct {
+	*store.InMemoryRepository
+	mu      sync.Mutex
+	batches [][]store.DeleteRequest
+}
+
+func (r *countingRepo) MarkDeletedBatch(ctx context.Context, reqs []store.DeleteRequest) error {
+	r.mu.Lock()
+	r.batches = append(r.batches, reqs)
+	r.mu.Unlock()
+	return r.InMemoryRepository.MarkDeletedBatch(ctx, reqs)
+}
+
+func TestDeletionsAreCoalesced(t *testing.T) {
+	repo := &countingRepo{InMemoryRepository: store.NewInMemoryRepository()}
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{DeleteBatchSize: 4, DeleteFlushInterval: time.Hour})
+	ctx := context.Background()
+
+	require.NoError(t, svc.MarkDeletedAsync(ctx, "u1", []string{"a", "b"}))
+	require.NoError(t, svc.MarkDeletedAsync(ctx, "u2", []string{"c"}))
+	require.NoError(t, svc.MarkDeletedAsync(ctx, "u1", []string{"b", "d"}))
+	svc.Close()
+
+	require.Len(t, repo.batches, 1)
+	ids := map[string][]string{}
+	for _, req := range repo.batches[0] {
+		sort.Strings(req.ShortIDs)
+		ids[req.UserID] = req.ShortIDs
+	}
+	assert.Equal(t, map[string][]string{"u1": {"a", "b", "d"}, "u2": {"c"}}, ids)
+}
+
+type stuckRepo struct {
+	*store.InMemoryRepository
+}
+
+func (r *stuckRepo) MarkDeletedBatch(ctx context.Context, reqs []store.DeleteRequest) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdownIsBoundedByContext(t *testing.T) {
+	svc := NewURLService(&stuckRepo{store.NewInMemoryRepository()}, zap.NewNop().Sugar(), Options{DeleteFlushInterval: time.Hour})
+	require.NoError(t, svc.MarkDeletedAsync(context.Background(), "u1", []string{"a"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, svc.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestLargeDeletionsAreChunked(t *testing.T) {
+	repo := &countingRepo{InMemoryRepository: store.NewInMemoryRepository()}
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{DeleteBatchSize: 3, DeleteFlushInterval: time.Hour})
+	ctx := context.Background()
+
+	require.NoError(t, svc.MarkDeletedAsync(ctx, "u1", []string{"a", "b", "c", "d", "e", "f", "g"}))
+	svc.Close()
+
+	var ids []string
+	for _, batch := range repo.batches {
+		n := 0
+		for _, req := range batch {
+			assert.Equal(t, "u1", req.UserID)
+			assert.NotEmpty(t, req.ShortIDs)
+			n += len(req.ShortIDs)
+			ids = append(ids, req.ShortIDs...)
+		}
+		assert.LessOrEqual(t, n, 3)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, ids)
+	assert.Len(t, repo.batches, 3)
+}
+
+func TestShutdownReleasesBlockedSenders(t *testing.T) {
+	svc := NewURLService(&stuckRepo{store.NewInMemoryRepository()}, zap.NewNop().Sugar(), Options{DeleteQueueSize: 1, DeleteBatchSize: 1, DeleteFlushInterval: time.Hour})
+	bg := context.Background()
+
+	// The first request is being written, the second fills the queue and
+	// the third waits for room.
+	require.NoError(t, svc.MarkDeletedAsync(bg, "u1", []string{"a"}))
+	require.Eventually(t, func() bool { return svc.DeleteQueueDepth() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, svc.MarkDeletedAsync(bg, "u1", []string{"b"}))
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- svc.MarkDeletedAsync(bg, "u1", []string{"c"})
+	}()
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, svc.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.ErrorIs(t, <-blocked, ErrShuttingDown)
+}
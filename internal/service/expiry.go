@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrInvalidExpiry = errors.New("invalid expiry: use either ttl_seconds > 0 or a future expires_at")
+
+func ResolveExpiry(ttlSeconds int64, expiresAt *time.Time, now time.Time) (*time.Time, error) {
+	switch {
+	case ttlSeconds == 0 && expiresAt == nil:
+		return nil, nil
+	case ttlSeconds != 0 && expiresAt != nil:
+		return nil, ErrInvalidExpiry
+	case ttlSeconds < 0:
+		return nil, ErrInvalidExpiry
+	case ttlSeconds > 0:
+		at := now.Add(time.Duration(ttlSeconds) * time.Second).UTC()
+		return &at, nil
+	}
+
+	if !expiresAt.After(now) {
+		return nil, ErrInvalidExpiry
+	}
+	at := expiresAt.UTC()
+	return &at, nil
+}
+
+func (s *URLService) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.repo.ExpireURLs(ctx, now)
+			if err != nil {
+				s.logger.Errorf("failed to expire urls: %v", err)
+				continue
+			}
+			if n > 0 {
+				s.logger.Infof("expired %d urls", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveExpiry(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(48 * time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		ttl       int64
+		expiresAt *time.Time
+		want      *time.Time
+		wantErr   bool
+	}{
+		{name: "no expiry", want: nil},
+		{name: "ttl", ttl: 60, want: ptr(now.Add(time.Minute))},
+		{name: "absolute", expiresAt: &future, want: &future},
+		{name: "both set", ttl: 60, expiresAt: &future, wantErr: true},
+		{name: "negative ttl", ttl: -1, wantErr: true},
+		{name: "absolute in the past", expiresAt: &past, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveExpiry(tt.ttl, tt.expiresAt, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidExpiry)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
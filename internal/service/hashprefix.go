@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	minHashPrefixLen = 4
+	maxHostSuffixes  = 5
+	maxPathPrefixes  = 4
+)
+
+// HashPrefixChecker blocks destinations whose hashed URL expressions appear in
+// a local database, in the style of Safe Browsing. The file lists hex SHA-256
+// prefixes (4 to 32 bytes), one per line; '#' starts a comment. Expressions
+// are host suffixes combined with path prefixes, e.g. for
+// https://a.b.example/1/2.html?q=3:
+//
+//	a.b.example/1/2.html?q=3  a.b.example/1/2.html  a.b.example/1/  a.b.example/
+//	b.example/1/2.html?q=3    ...
+//
+// A prefix match blocks the link; there is no full-hash confirmation, so the
+// database should use prefixes long enough to make false positives unlikely.
+type HashPrefixChecker struct {
+	file     watchedFile
+	prefixes atomic.Pointer[hashPrefixes]
+}
+
+// hashPrefixes holds the known prefixes grouped by length in bytes.
+type hashPrefixes map[int]map[string]struct{}
+
+func NewHashPrefixChecker(path string) (*HashPrefixChecker, error) {
+	c := &HashPrefixChecker{file: watchedFile{path: path}}
+	if err := c.file.load(true, c.parse); err != nil {
+		return nil, fmt.Errorf("hash prefix database %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *HashPrefixChecker) ReloadIfChanged() error {
+	if err := c.file.load(false, c.parse); err != nil {
+		return fmt.Errorf("hash prefix database %s: %w", c.file.path, err)
+	}
+	return nil
+}
+
+func (c *HashPrefixChecker) parse(data []byte) error {
+	prefixes := make(hashPrefixes)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		prefix, err := hex.DecodeString(line)
+		if err != nil || len(prefix) < minHashPrefixLen || len(prefix) > sha256.Size {
+			return fmt.Errorf("line %d: want %d to %d hex-encoded bytes", n, minHashPrefixLen, sha256.Size)
+		}
+		if prefixes[len(prefix)] == nil {
+			prefixes[len(prefix)] = make(map[string]struct{})
+		}
+		prefixes[len(prefix)][string(prefix)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	c.prefixes.Store(&prefixes)
+	return nil
+}
+
+func (c *HashPrefixChecker) Check(ctx context.Context, originalURL string) (SafetyVerdict, error) {
+	prefixes := *c.prefixes.Load()
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return SafetyVerdict{}, err
+	}
+
+	for _, expr := range urlExpressions(u) {
+		sum := sha256.Sum256([]byte(expr))
+		for n, set := range prefixes {
+			if _, ok := set[string(sum[:n])]; ok {
+				return SafetyVerdict{Blocked: true, Reason: "destination is listed in the threat database"}, nil
+			}
+		}
+	}
+	return SafetyVerdict{}, nil
+}
+
+// urlExpressions lists the host suffix / path prefix combinations to look up.
+func urlExpressions(u *url.URL) []string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		// Suffixes of at least two labels, starting from the shortest.
+		start := len(labels) - maxHostSuffixes
+		if start < 1 {
+			start = 1
+		}
+		for i := start; i <= len(labels)-2; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var paths []string
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	dirs := 0
+	for i := 0; i < len(path) && dirs < maxPathPrefixes; i++ {
+		if path[i] == '/' && path[:i+1] != path {
+			paths = append(paths, path[:i+1])
+			dirs++
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var exprs []string
+	for _, h := range hosts {
+		for _, p := range paths {
+			expr := h + p
+			if _, dup := seen[expr]; !dup {
+				seen[expr] = struct{}{}
+				exprs = append(exprs, expr)
+			}
+		}
+	}
+	return exprs
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"cuturl/internal/store"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	IDStrategyRandom     = "random"
+	IDStrategySequential = "sequential"
+	IDStrategyHash       = "hash"
+	IDStrategyRange      = "range"
+
+	DefaultIDAlphabet  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	DefaultIDLength    = 8
+	DefaultIDRangeSize = 1000
+)
+
+// maxIDAttempts bounds how many candidate IDs are tried for one URL before
+// giving up with ErrIDSpaceExhausted.
+const maxIDAttempts = 5
+
+var (
+	ErrIDSpaceExhausted  = errors.New("no free short ID found")
+	ErrUnknownIDStrategy = errors.New("unknown ID strategy")
+)
+
+// IDGenerator proposes short IDs. attempt counts the collisions already seen
+// for this URL, so deterministic generators can derive a different candidate.
+type IDGenerator interface {
+	Generate(ctx context.Context, originalURL string, attempt int) (string, error)
+}
+
+// NewIDGenerator builds the generator for strategy. next is the first value
+// handed out by the sequential strategy and is ignored by the others. The
+// range strategy is built with NewRangeIDGenerator instead.
+func NewIDGenerator(strategy, alphabet string, length int, next uint64) (IDGenerator, error) {
+	if err := ValidateIDAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("invalid ID length %d", length)
+	}
+	switch strategy {
+	case IDStrategyRandom:
+		return &RandomIDGenerator{alphabet: alphabet, length: length}, nil
+	case IDStrategySequential:
+		return NewSequentialIDGenerator(alphabet, length, next), nil
+	case IDStrategyHash:
+		return &HashIDGenerator{alphabet: alphabet, length: length}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIDStrategy, strategy)
+	}
+}
+
+// ValidateIDAlphabet checks that alphabet has at least two characters, all
+// distinct and unreserved in the sense of RFC 3986, so IDs never need
+// escaping in a URL path.
+func ValidateIDAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("ID alphabet needs at least two characters")
+	}
+	seen := make(map[byte]struct{}, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if !isUnreserved(c) {
+			return fmt.Errorf("ID alphabet contains invalid character %q", c)
+		}
+		if _, dup := seen[c]; dup {
+			return fmt.Errorf("ID alphabet repeats %q", c)
+		}
+		seen[c] = struct{}{}
+	}
+	return nil
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '~'
+}
+
+// RandomIDGenerator draws every character independently from crypto/rand.
+type RandomIDGenerator struct {
+	alphabet string
+	length   int
+}
+
+func (g *RandomIDGenerator) Generate(context.Context, string, int) (string, error) {
+	b := make([]byte, g.length)
+	max := big.NewInt(int64(len(g.alphabet)))
+	for i := range b {
+		num, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.alphabet[num.Int64()]
+	}
+	return string(b), nil
+}
+
+// SequentialIDGenerator encodes an in-process counter, left-padded to length
+// with the first alphabet character. IDs grow past length once the padded
+// space is used up.
+type SequentialIDGenerator struct {
+	alphabet string
+	length   int
+	next     atomic.Uint64
+}
+
+func NewSequentialIDGenerator(alphabet string, length int, next uint64) *SequentialIDGenerator {
+	g := &SequentialIDGenerator{alphabet: alphabet, length: length}
+	g.next.Store(next)
+	return g
+}
+
+func (g *SequentialIDGenerator) Generate(context.Context, string, int) (string, error) {
+	return encodeID(g.next.Add(1)-1, g.alphabet, g.length), nil
+}
+
+// HashIDGenerator derives the ID from a SHA-256 of the URL, so the same URL
+// always maps to the same ID. Collisions are resolved by salting the hash
+// with the attempt number.
+type HashIDGenerator struct {
+	alphabet string
+	length   int
+}
+
+func (g *HashIDGenerator) Generate(_ context.Context, originalURL string, attempt int) (string, error) {
+	input := originalURL
+	if attempt > 0 {
+		input += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(input))
+
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(g.alphabet)))
+	mod := new(big.Int)
+	b := make([]byte, g.length)
+	for i := range b {
+		if n.Sign() == 0 {
+			// Only very long IDs use up all 256 bits; keep hashing.
+			sum = sha256.Sum256(sum[:])
+			n.SetBytes(sum[:])
+		}
+		n.DivMod(n, base, mod)
+		b[i] = g.alphabet[mod.Int64()]
+	}
+	return string(b), nil
+}
+
+// RangeIDGenerator hands out sequential IDs from blocks leased through an
+// IDRangeLeaser, so replicas sharing a database never issue the same ID and
+// only go to the database once per block.
+type RangeIDGenerator struct {
+	alphabet string
+	length   int
+	leaser   store.IDRangeLeaser
+	size     uint64
+
+	mu   sync.Mutex
+	next uint64
+	end  uint64
+}
+
+func NewRangeIDGenerator(leaser store.IDRangeLeaser, alphabet string, length int, size uint64) (*RangeIDGenerator, error) {
+	if err := ValidateIDAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("invalid ID length %d", length)
+	}
+	if size == 0 {
+		size = DefaultIDRangeSize
+	}
+	return &RangeIDGenerator{alphabet: alphabet, length: length, leaser: leaser, size: size}, nil
+}
+
+func (g *RangeIDGenerator) Generate(ctx context.Context, _ string, _ int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == g.end {
+		start, err := g.leaser.LeaseIDRange(ctx, g.size)
+		if err != nil {
+			return "", fmt.Errorf("lease id range: %w", err)
+		}
+		g.next, g.end = start, start+g.size
+	}
+	id := encodeID(g.next, g.alphabet, g.length)
+	g.next++
+	return id, nil
+}
+
+// localRangeLeaser is the fallback for repositories that can't lease ranges.
+// It is only safe for a single instance.
+type localRangeLeaser struct {
+	next atomic.Uint64
+}
+
+func (l *localRangeLeaser) LeaseIDRange(_ context.Context, size uint64) (uint64, error) {
+	return l.next.Add(size) - size, nil
+}
+
+func encodeID(n uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+	var b []byte
+	for n > 0 {
+		b = append(b, alphabet[n%base])
+		n /= base
+	}
+	for len(b) < length {
+		b = append(b, alphabet[0])
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
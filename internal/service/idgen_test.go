@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestIDGenerators(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		alphabet string
+		length   int
+		check    func(t *testing.T, g IDGenerator)
+	}{
+		{
+			name:     "random uses alphabet and length",
+			strategy: IDStrategyRandom,
+			alphabet: "ab",
+			length:   12,
+			check: func(t *testing.T, g IDGenerator) {
+				id, err := g.Generate(context.Background(), "https://example.com", 0)
+				require.NoError(t, err)
+				assert.Len(t, id, 12)
+				assert.Regexp(t, `^[ab]+$`, id)
+			},
+		},
+		{
+			name:     "sequential counts in base62",
+			strategy: IDStrategySequential,
+			alphabet: DefaultIDAlphabet,
+			length:   3,
+			check: func(t *testing.T, g IDGenerator) {
+				var ids []string
+				for i := 0; i < 3; i++ {
+					id, err := g.Generate(context.Background(), "", 0)
+					require.NoError(t, err)
+					ids = append(ids, id)
+				}
+				assert.Equal(t, []string{"a99", "baa", "bab"}, ids)
+			},
+		},
+		{
+			name:     "hash is deterministic and salted by attempt",
+			strategy: IDStrategyHash,
+			alphabet: DefaultIDAlphabet,
+			length:   8,
+			check: func(t *testing.T, g IDGenerator) {
+				first, err := g.Generate(context.Background(), "https://example.com", 0)
+				require.NoError(t, err)
+				again, err := g.Generate(context.Background(), "https://example.com", 0)
+				require.NoError(t, err)
+				retry, err := g.Generate(context.Background(), "https://example.com", 1)
+				require.NoError(t, err)
+				assert.Len(t, first, 8)
+				assert.Equal(t, first, again)
+				assert.NotEqual(t, first, retry)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewIDGenerator(tt.strategy, tt.alphabet, tt.length, 62*62-1)
+			require.NoError(t, err)
+			tt.check(t, g)
+		})
+	}
+}
+
+func TestNewIDGeneratorRejectsBadOptions(t *testing.T) {
+	_, err := NewIDGenerator("uuid", DefaultIDAlphabet, 8, 0)
+	assert.ErrorIs(t, err, ErrUnknownIDStrategy)
+	_, err = NewIDGenerator(IDStrategyRandom, "aa", 8, 0)
+	assert.Error(t, err)
+	_, err = NewIDGenerator(IDStrategyRandom, "a/b", 8, 0)
+	assert.Error(t, err)
+	_, err = NewIDGenerator(IDStrategyRandom, "a.b", 8, 0)
+	assert.Error(t, err)
+	_, err = NewIDGenerator(IDStrategyRandom, "ab-_~", 8, 0)
+	assert.NoError(t, err)
+	_, err = NewIDGenerator(IDStrategyRandom, DefaultIDAlphabet, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestShortenRetriesOnCollision(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, store.StoredURL{UUID: "aaaa", ShortURL: "aaaa", OriginalURL: "https://example.com/mine", UserID: "u1"}))
+
+	// The counter starts at an ID someone else already owns.
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDGenerator: NewSequentialIDGenerator("ab", 4, 0)})
+	defer svc.Close()
+
+	entry, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com/yours", UserID: "u2"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "aaab", entry.ShortURL)
+
+	owner, err := repo.FindByShortID(ctx, "aaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/mine", owner.OriginalURL)
+}
+
+// attemptIDs proposes the attempt'th ID of its list.
+type attemptIDs []string
+
+func (g attemptIDs) Generate(_ context.Context, _ string, attempt int) (string, error) {
+	return g[attempt], nil
+}
+
+func TestShortenSkipsReservedIDs(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	ctx := context.Background()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDGenerator: attemptIDs{"api", "abc", "abd"}})
+	defer svc.Close()
+
+	entry, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com/one", UserID: "u1"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "abc", entry.ShortURL)
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{{OriginalURL: "https://example.com/two", UserID: "u1"}})
+	require.NoError(t, err)
+	require.Equal(t, BatchItemCreated, results[0].Status)
+	assert.Equal(t, "abd", results[0].URL.ShortURL)
+}
+
+func TestShortenHashReturnsExisting(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	ctx := context.Background()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDStrategy: IDStrategyHash})
+	defer svc.Close()
+
+	first, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com", UserID: "u1"})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	again, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com", UserID: "u1"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ShortURL, again.ShortURL)
+}
+
+func TestShortenHashPerUserScope(t *testing.T) {
+	repo := store.NewInMemoryRepository(store.WithDedupScope(store.DedupPerUser))
+	ctx := context.Background()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDStrategy: IDStrategyHash})
+	defer svc.Close()
+
+	mine, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com", UserID: "u1"})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	// Same URL, same hash, but another user's link: u2 gets a link of their own.
+	yours, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com", UserID: "u2"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, mine.ShortURL, yours.ShortURL)
+	assert.Equal(t, "u2", yours.UserID)
+
+	again, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com", UserID: "u2"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, yours.ShortURL, again.ShortURL)
+}
+
+type countingLeaser struct {
+	store.IDRangeLeaser
+	leases int
+}
+
+func (l *countingLeaser) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	l.leases++
+	return l.IDRangeLeaser.LeaseIDRange(ctx, size)
+}
+
+func TestRangeIDGeneratorLeasesBlocks(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	leaser := &countingLeaser{IDRangeLeaser: repo}
+
+	// Another replica already took the first block.
+	_, err := repo.LeaseIDRange(ctx, 3)
+	require.NoError(t, err)
+
+	g, err := NewRangeIDGenerator(leaser, "abc", 2, 3)
+	require.NoError(t, err)
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, err := g.Generate(ctx, "", 0)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []string{"ba", "bb", "bc", "ca"}, ids)
+	assert.Equal(t, 2, leaser.leases)
+}
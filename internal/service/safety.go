@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrUnsafeURL matches every *UnsafeURLError.
+var ErrUnsafeURL = errors.New("destination is blocked")
+
+// UnsafeURLError is returned when a SafetyChecker blocks the original URL.
+type UnsafeURLError struct {
+	Reason string
+}
+
+func (e *UnsafeURLError) Error() string {
+	return ErrUnsafeURL.Error() + ": " + e.Reason
+}
+
+func (e *UnsafeURLError) Is(target error) bool {
+	return target == ErrUnsafeURL
+}
+
+type SafetyVerdict struct {
+	Blocked bool
+	// Reason says which rule matched; it is shown to users.
+	Reason string
+}
+
+// SafetyChecker decides whether a destination may be shortened and redirected
+// to. Check gets the canonical URL.
+type SafetyChecker interface {
+	Check(ctx context.Context, originalURL string) (SafetyVerdict, error)
+}
+
+// reloader is implemented by checkers backed by a file that may change while
+// the server runs.
+type reloader interface {
+	ReloadIfChanged() error
+}
+
+// CheckSafety runs every configured checker and returns the first block.
+func (s *URLService) CheckSafety(ctx context.Context, originalURL string) (SafetyVerdict, error) {
+	for _, c := range s.safety {
+		v, err := c.Check(ctx, originalURL)
+		if err != nil || v.Blocked {
+			return v, err
+		}
+	}
+	return SafetyVerdict{}, nil
+}
+
+func (s *URLService) ensureSafe(ctx context.Context, originalURL string) error {
+	v, err := s.CheckSafety(ctx, originalURL)
+	if err != nil {
+		return err
+	}
+	if v.Blocked {
+		return &UnsafeURLError{Reason: v.Reason}
+	}
+	return nil
+}
+
+// RunSafetyReloader picks up changes to file-backed checkers every interval
+// until ctx is done. A file that fails to load leaves the previous rules in
+// place.
+func (s *URLService) RunSafetyReloader(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, c := range s.safety {
+				if r, ok := c.(reloader); ok {
+					if err := r.ReloadIfChanged(); err != nil {
+						s.logger.Errorw("failed to reload safety rules", "error", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// watchedFile remembers the modification time and size a file had when it was
+// last loaded.
+type watchedFile struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// load calls parse with the file's contents if it changed since the last
+// successful load, or unconditionally when force is set.
+func (f *watchedFile) load(force bool, parse func(data []byte) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if !force && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := parse(data); err != nil {
+		return err
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"cuturl/internal/store"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func writeRules(t *testing.T, path, rules string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestBlocklistChecker(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeRules(t, path, "# phishing\nphish.example\n*.Bücher.example\n/login\\.php\\?acct=/\n", time.Now().Add(-time.Hour))
+
+	b, err := NewBlocklistChecker(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://phish.example/", true},
+		{"https://a.b.phish.example/x", true},
+		{"https://notphish.example/", false},
+		{"https://xn--bcher-kva.example/", true},
+		{"https://shop.xn--bcher-kva.example/", true},
+		{"https://example.com/login.php?acct=1", true},
+		{"https://example.com/login.php", false},
+	}
+	for _, tt := range tests {
+		v, err := b.Check(ctx, tt.url)
+		require.NoError(t, err)
+		assert.Equal(t, tt.blocked, v.Blocked, tt.url)
+	}
+
+	// Unchanged files are not reparsed; changed ones are, and a broken file
+	// keeps the previous rules.
+	require.NoError(t, b.ReloadIfChanged())
+	writeRules(t, path, "other.example\n", time.Now())
+	require.NoError(t, b.ReloadIfChanged())
+	v, _ := b.Check(ctx, "https://phish.example/")
+	assert.False(t, v.Blocked)
+	v, _ = b.Check(ctx, "https://other.example/")
+	assert.True(t, v.Blocked)
+
+	writeRules(t, path, "/[/\n", time.Now().Add(time.Hour))
+	assert.Error(t, b.ReloadIfChanged())
+	v, _ = b.Check(ctx, "https://other.example/")
+	assert.True(t, v.Blocked)
+
+	writeRules(t, path, "bad domain\n", time.Now())
+	_, err = NewBlocklistChecker(path)
+	assert.Error(t, err)
+}
+
+func TestHashPrefixChecker(t *testing.T) {
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte("evil.example/malware/"))
+	path := filepath.Join(t.TempDir(), "prefixes.txt")
+	writeRules(t, path, "# threat list\n"+hex.EncodeToString(sum[:4])+"\n", time.Now())
+
+	c, err := NewHashPrefixChecker(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://evil.example/malware/", true},
+		{"https://evil.example/malware/payload.exe?x=1", true},
+		{"https://cdn.evil.example/malware/a/b", true},
+		{"https://evil.example/", false},
+		{"https://good.example/malware/", false},
+	}
+	for _, tt := range tests {
+		v, err := c.Check(ctx, tt.url)
+		require.NoError(t, err)
+		assert.Equal(t, tt.blocked, v.Blocked, tt.url)
+	}
+
+	writeRules(t, path, "abcd\n", time.Now())
+	_, err = NewHashPrefixChecker(path)
+	assert.Error(t, err)
+}
+
+func TestURLExpressions(t *testing.T) {
+	u, err := url.Parse("http://a.b.c/1/2.html?param=1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"a.b.c/1/2.html?param=1", "a.b.c/1/2.html", "a.b.c/", "a.b.c/1/",
+		"b.c/1/2.html?param=1", "b.c/1/2.html", "b.c/", "b.c/1/",
+	}, urlExpressions(u))
+}
+
+type stubChecker struct {
+	blocked map[string]string
+	err     error
+}
+
+func (c stubChecker) Check(ctx context.Context, originalURL string) (SafetyVerdict, error) {
+	if reason, ok := c.blocked[originalURL]; ok {
+		return SafetyVerdict{Blocked: true, Reason: reason}, nil
+	}
+	return SafetyVerdict{}, c.err
+}
+
+func TestShortenRejectsUnsafeURLs(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{
+		SafetyCheckers: []SafetyChecker{stubChecker{blocked: map[string]string{"https://bad.example/": "malware"}}},
+	})
+	defer svc.Close()
+
+	_, _, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "HTTPS://BAD.example:443/", UserID: "u1"})
+	var unsafeErr *UnsafeURLError
+	require.True(t, errors.As(err, &unsafeErr), "want *UnsafeURLError, got %v", err)
+	assert.Equal(t, "malware", unsafeErr.Reason)
+	assert.ErrorIs(t, err, ErrUnsafeURL)
+
+	err = svc.SaveURL(ctx, store.StoredURL{ShortURL: "abc", OriginalURL: "https://bad.example/", UserID: "u1"})
+	assert.ErrorIs(t, err, ErrUnsafeURL)
+	_, err = repo.FindByShortID(ctx, "abc")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{
+		{OriginalURL: "https://good.example/", UserID: "u1"},
+		{OriginalURL: "https://bad.example/", UserID: "u1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BatchItemCreated, results[0].Status)
+	assert.Equal(t, BatchItemBlocked, results[1].Status)
+	assert.Equal(t, "malware", results[1].Reason)
+
+	// Checker failures stop creation rather than letting the link through.
+	failing := NewURLService(repo, zap.NewNop().Sugar(), Options{
+		SafetyCheckers: []SafetyChecker{stubChecker{err: errors.New("lookup failed")}},
+	})
+	defer failing.Close()
+	_, _, err = failing.Shorten(ctx, ShortenInput{OriginalURL: "https://other.example/", UserID: "u1"})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsafeURL)
+}
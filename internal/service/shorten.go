@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"time"
+)
+
+type ShortenInput struct {
+	OriginalURL string
+	Alias       string
+	UserID      string
+	ExpiresAt   *time.Time
+}
+
+type BatchItemStatus string
+
+const (
+	BatchItemCreated BatchItemStatus = "created"
+	BatchItemExists  BatchItemStatus = "exists"
+	BatchItemInvalid BatchItemStatus = "invalid"
+	BatchItemBlocked BatchItemStatus = "blocked"
+	// BatchItemFailed means the item could not be saved after other items in
+	// the batch already were.
+	BatchItemFailed BatchItemStatus = "failed"
+)
+
+// BatchItemResult is the outcome of one ShortenBatch item. URL is set for
+// created and existing items, Reason for the others; Code is the URLError
+// code when the original URL itself was rejected.
+type BatchItemResult struct {
+	Status BatchItemStatus
+	URL    *store.StoredURL
+	Reason string
+	Code   string
+}
+
+var (
+	ErrDuplicateAlias = errors.New("alias used more than once in batch")
+	ErrEmptyURL       = errors.New("empty URL")
+)
+
+func (in ShortenInput) entry(shortID string) store.StoredURL {
+	return store.StoredURL{
+		UUID:        shortID,
+		ShortURL:    shortID,
+		OriginalURL: in.OriginalURL,
+		UserID:      in.UserID,
+		ExpiresAt:   in.ExpiresAt,
+	}
+}
+
+// CanonicalURL validates raw against the service's URLPolicy and returns the
+// form that is stored. Errors are *URLError.
+func (s *URLService) CanonicalURL(raw string) (string, error) {
+	return s.urls.Canonicalize(raw)
+}
+
+// Shorten stores a new short link under the canonical form of the original
+// URL, after applying the short link policy to it. If that was already shortened within the repository's dedup scope the
+// existing link is returned and created is false. Generated IDs that
+// collide with another link are replaced, up to maxIDAttempts times.
+func (s *URLService) Shorten(ctx context.Context, in ShortenInput) (*store.StoredURL, bool, error) {
+	var err error
+	if in.OriginalURL, err = s.destination(ctx, in.OriginalURL); err != nil {
+		return nil, false, err
+	}
+	if in.Alias != "" {
+		entry := in.entry(in.Alias)
+		err := s.SaveAliasedURL(ctx, entry)
+		if err == nil {
+			return &entry, true, nil
+		}
+		return s.existingOr(ctx, in, err)
+	}
+
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		id, err := s.ids.Generate(ctx, in.OriginalURL, attempt)
+		if err != nil {
+			return nil, false, err
+		}
+		if isReserved(id) {
+			continue
+		}
+		entry := in.entry(id)
+		err = s.SaveURL(ctx, entry)
+		if err == nil {
+			return &entry, true, nil
+		}
+		if !errors.Is(err, store.ErrShortURLConflict) {
+			return s.existingOr(ctx, in, err)
+		}
+
+		// A deterministic generator maps the same URL to the same ID, so the
+		// "collision" may be this very URL. Whether that link is ours to
+		// return depends on the dedup scope; if not, the next attempt salts
+		// the ID.
+		existing, findErr := s.GetByOriginalURL(ctx, in.UserID, in.OriginalURL)
+		if findErr == nil {
+			return existing, false, nil
+		}
+		if !errors.Is(findErr, store.ErrNotFound) {
+			return nil, false, findErr
+		}
+	}
+	return nil, false, ErrIDSpaceExhausted
+}
+
+// existingOr returns the stored link for in.OriginalURL when err is a unique
+// violation on the original URL, and err otherwise.
+func (s *URLService) existingOr(ctx context.Context, in ShortenInput, err error) (*store.StoredURL, bool, error) {
+	if !errors.Is(err, store.ErrUniqueViolation) {
+		return nil, false, err
+	}
+	existing, findErr := s.GetByOriginalURL(ctx, in.UserID, in.OriginalURL)
+	if errors.Is(findErr, store.ErrNotFound) {
+		return nil, false, err
+	}
+	if findErr != nil {
+		return nil, false, findErr
+	}
+	return existing, false, nil
+}
+
+// ShortenBatch stores every valid item and reports a result per item, in
+// input order. Invalid or blocked items and taken aliases don't stop the rest
+// of the batch. Repository and safety checker failures are returned as an
+// error while no item has been created; once one has, items that can't be
+// saved, for want of a free ID for instance, are reported as BatchItemFailed
+// instead, so the caller learns about the ones that were.
+func (s *URLService) ShortenBatch(ctx context.Context, items []ShortenInput) ([]BatchItemResult, error) {
+	results := make([]BatchItemResult, len(items))
+	aliases := make(map[string]struct{})
+	// Canonical URLs replace the originals in a copy, not in the caller's slice.
+	items = append([]ShortenInput(nil), items...)
+
+	pending := make([]int, 0, len(items))
+	for i, in := range items {
+		canonical, err := s.destination(ctx, in.OriginalURL)
+		var urlErr *URLError
+		if errors.As(err, &urlErr) {
+			results[i] = BatchItemResult{Status: BatchItemInvalid, Reason: urlErr.Message, Code: urlErr.Code}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items[i].OriginalURL = canonical
+		verdict, err := s.CheckSafety(ctx, canonical)
+		if err != nil {
+			return nil, err
+		}
+		if verdict.Blocked {
+			results[i] = BatchItemResult{Status: BatchItemBlocked, Reason: verdict.Reason}
+			continue
+		}
+		if in.Alias != "" {
+			if err := ValidateAlias(in.Alias); err != nil {
+				results[i] = BatchItemResult{Status: BatchItemInvalid, Reason: err.Error()}
+				continue
+			}
+			if _, dup := aliases[in.Alias]; dup {
+				results[i] = BatchItemResult{Status: BatchItemInvalid, Reason: ErrDuplicateAlias.Error()}
+				continue
+			}
+			aliases[in.Alias] = struct{}{}
+		}
+		pending = append(pending, i)
+	}
+
+	created := 0
+	fail := func(pending []int, err error) ([]BatchItemResult, error) {
+		if created == 0 {
+			return nil, err
+		}
+		s.logger.Errorw("batch items not saved after others were", "items", len(pending), "error", err)
+		for _, i := range pending {
+			results[i] = BatchItemResult{Status: BatchItemFailed, Reason: err.Error()}
+		}
+		return results, nil
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == maxIDAttempts {
+			return fail(pending, ErrIDSpaceExhausted)
+		}
+		var retry, batch []int
+		entries := make([]store.StoredURL, 0, len(pending))
+		for _, i := range pending {
+			id := items[i].Alias
+			if id == "" {
+				var err error
+				if id, err = s.ids.Generate(ctx, items[i].OriginalURL, attempt); err != nil {
+					return fail(pending, err)
+				}
+				if isReserved(id) {
+					retry = append(retry, i)
+					continue
+				}
+			}
+			batch = append(batch, i)
+			entries = append(entries, items[i].entry(id))
+		}
+
+		if len(entries) == 0 {
+			pending = retry
+			continue
+		}
+		saved, err := s.repo.BatchSave(ctx, entries)
+		if err != nil {
+			return fail(pending, err)
+		}
+
+		for j, i := range batch {
+			switch saved[j].Status {
+			case store.BatchCreated:
+				results[i] = BatchItemResult{Status: BatchItemCreated, URL: &entries[j]}
+				created++
+			case store.BatchExists:
+				results[i] = BatchItemResult{Status: BatchItemExists, URL: saved[j].Existing}
+			case store.BatchConflict:
+				if items[i].Alias != "" {
+					results[i] = BatchItemResult{Status: BatchItemInvalid, Reason: ErrAliasTaken.Error()}
+				} else {
+					retry = append(retry, i)
+				}
+			}
+		}
+		pending = retry
+	}
+	return results, nil
+}
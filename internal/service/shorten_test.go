@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestShortenBatchPartialSuccess(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{})
+	defer svc.Close()
+	ctx := context.Background()
+
+	existing, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com/old", UserID: "u1"})
+	require.NoError(t, err)
+	require.True(t, created)
+	_, _, err = svc.Shorten(ctx, ShortenInput{OriginalURL: "https://example.com/taken", Alias: "taken", UserID: "u1"})
+	require.NoError(t, err)
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{
+		{OriginalURL: "https://example.com/new", UserID: "u1"},
+		{OriginalURL: "https://example.com/old", UserID: "u1"},
+		{OriginalURL: "", UserID: "u1"},
+		{OriginalURL: "https://example.com/other", Alias: "taken", UserID: "u1"},
+		{OriginalURL: "https://example.com/bad", Alias: "no", UserID: "u1"},
+		{OriginalURL: "https://example.com/new", UserID: "u1"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	assert.Equal(t, BatchItemCreated, results[0].Status)
+	require.NotNil(t, results[0].URL)
+	assert.Equal(t, BatchItemExists, results[1].Status)
+	assert.Equal(t, existing.ShortURL, results[1].URL.ShortURL)
+	assert.Equal(t, BatchItemInvalid, results[2].Status)
+	assert.Equal(t, ErrEmptyURL.Error(), results[2].Reason)
+	assert.Equal(t, BatchItemInvalid, results[3].Status)
+	assert.Equal(t, ErrAliasTaken.Error(), results[3].Reason)
+	assert.Equal(t, BatchItemInvalid, results[4].Status)
+	assert.Equal(t, BatchItemExists, results[5].Status)
+	assert.Equal(t, results[0].URL.ShortURL, results[5].URL.ShortURL)
+
+	saved, err := repo.FindByOriginalURL(ctx, "u1", "https://example.com/new")
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+}
+
+type fixedIDs string
+
+func (g fixedIDs) Generate(context.Context, string, int) (string, error) {
+	return string(g), nil
+}
+
+func TestShortenBatchReportsExhaustedItems(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDGenerator: fixedIDs("same")})
+	defer svc.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.Save(ctx, store.StoredURL{UUID: "same", ShortURL: "same", OriginalURL: "https://example.com/first", UserID: "u1"}))
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{
+		{OriginalURL: "https://example.com/aliased", Alias: "mine", UserID: "u1"},
+		{OriginalURL: "https://example.com/generated", UserID: "u1"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, BatchItemCreated, results[0].Status)
+	assert.Equal(t, BatchItemFailed, results[1].Status)
+	assert.Equal(t, ErrIDSpaceExhausted.Error(), results[1].Reason)
+
+	_, err = repo.FindByShortID(ctx, "mine")
+	assert.NoError(t, err, "the created item is reported and kept")
+}
+
+func TestShortenBatchFailsWhenNothingWasCreated(t *testing.T) {
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{IDGenerator: fixedIDs("same")})
+	defer svc.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.Save(ctx, store.StoredURL{UUID: "same", ShortURL: "same", OriginalURL: "https://example.com/first", UserID: "u1"}))
+
+	// An existing link is found, not written, so the batch can still fail as a whole.
+	_, err := svc.ShortenBatch(ctx, []ShortenInput{
+		{OriginalURL: "https://example.com/first", UserID: "u1"},
+		{OriginalURL: "https://example.com/generated", UserID: "u1"},
+	})
+	assert.ErrorIs(t, err, ErrIDSpaceExhausted)
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// What Shorten does with an original URL that is itself a short link.
+const (
+	ShortLinkReject  = "reject"
+	ShortLinkResolve = "resolve"
+)
+
+// maxShortLinkHops bounds how many short links are followed, both when
+// resolving one at creation time and when checking a stored chain at
+// redirect time.
+const maxShortLinkHops = 5
+
+// ErrRedirectLoop is returned by CheckRedirectLoop when following a link's
+// destination through this shortener leads back to it, or goes on for more
+// than maxShortLinkHops links.
+var ErrRedirectLoop = errors.New("short link redirects in a loop")
+
+// shortLinks recognises original URLs that point at this shortener or at
+// another one.
+type shortLinks struct {
+	// base is the canonical BaseURL; nil if none was configured.
+	base   *url.URL
+	hosts  []string
+	policy string
+	client *http.Client
+}
+
+func newShortLinks(urls URLPolicy, opts Options) shortLinks {
+	l := shortLinks{policy: opts.ShortLinkPolicy, client: opts.HTTPClient}
+	if l.policy == "" {
+		l.policy = ShortLinkReject
+	}
+	if opts.BaseURL != "" {
+		if canonical, err := urls.Canonicalize(opts.BaseURL); err == nil {
+			l.base, _ = url.Parse(canonical)
+		}
+	}
+	for _, h := range opts.ShortenerHosts {
+		if h, err := canonicalHost(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")); err == nil {
+			l.hosts = append(l.hosts, h)
+		}
+	}
+
+	// Redirects are followed one at a time, so every hop can be checked.
+	if l.client == nil {
+		l.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	client := *l.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	l.client = &client
+	return l
+}
+
+// own reports whether u is on this shortener's host and, if it has the shape
+// of a short link, its ID.
+func (l shortLinks) own(u *url.URL) (id string, ok bool) {
+	if l.base == nil || !strings.EqualFold(u.Host, l.base.Host) {
+		return "", false
+	}
+	prefix := l.base.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	id, found := strings.CutPrefix(u.Path, prefix)
+	if !found || strings.Contains(id, "/") {
+		id = ""
+	}
+	return id, true
+}
+
+// external reports whether host is one of the configured shortener hosts or a
+// subdomain of one.
+func (l shortLinks) external(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range l.hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// follow asks another shortener where link points, without following the
+// redirect itself.
+func (l shortLinks) follow(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+		return "", fmt.Errorf("got %s instead of a redirect", resp.Status)
+	}
+	next, err := req.URL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
+
+// destination canonicalizes raw and applies the short link policy: links to
+// this or another shortener are rejected, or replaced by the URL they
+// eventually redirect to. Errors other than repository failures are
+// *URLError.
+func (s *URLService) destination(ctx context.Context, raw string) (string, error) {
+	target, err := s.CanonicalURL(raw)
+	if err != nil {
+		return "", err
+	}
+
+	seen := make(map[string]struct{})
+	for {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", &URLError{Code: URLErrMalformed, Message: "cannot parse URL"}
+		}
+		id, own := s.links.own(u)
+		if !own && !s.links.external(u.Hostname()) {
+			return target, nil
+		}
+		if s.links.policy != ShortLinkResolve {
+			return "", &URLError{Code: URLErrShortLink, Message: "URL is already a short link; shorten its destination instead"}
+		}
+		if _, dup := seen[target]; dup || len(seen) == maxShortLinkHops {
+			return "", &URLError{Code: URLErrShortLink, Message: ErrRedirectLoop.Error()}
+		}
+		seen[target] = struct{}{}
+
+		var next string
+		if own {
+			next, err = s.ownDestination(ctx, id)
+		} else if next, err = s.links.follow(ctx, target); err != nil {
+			err = &URLError{Code: URLErrShortLink, Message: fmt.Sprintf("cannot resolve short link: %v", err)}
+		}
+		if err != nil {
+			return "", err
+		}
+		if target, err = s.CanonicalURL(next); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (s *URLService) ownDestination(ctx context.Context, id string) (string, error) {
+	if id == "" {
+		return "", &URLError{Code: URLErrShortLink, Message: "URL points at this shortener but is not a short link"}
+	}
+	entry, err := s.GetByShortID(ctx, id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (entry.IsDeleted || entry.IsExpired(time.Now()))) {
+		return "", &URLError{Code: URLErrShortLink, Message: fmt.Sprintf("short link %q does not exist", id)}
+	}
+	if err != nil {
+		return "", err
+	}
+	return entry.OriginalURL, nil
+}
+
+// CheckRedirectLoop follows entry's destination through links on this
+// shortener, which can still exist when they were stored before short link
+// detection or imported, and returns ErrRedirectLoop if it comes back around.
+// A chain that ends at a missing or removed link is not a loop; that hop
+// answers for itself.
+func (s *URLService) CheckRedirectLoop(ctx context.Context, entry *store.StoredURL) error {
+	seen := map[string]struct{}{entry.ShortURL: {}}
+	target := entry.OriginalURL
+	for {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil
+		}
+		id, own := s.links.own(u)
+		if !own || id == "" {
+			return nil
+		}
+		if _, dup := seen[id]; dup || len(seen) > maxShortLinkHops {
+			return ErrRedirectLoop
+		}
+		seen[id] = struct{}{}
+
+		next, err := s.GetByShortID(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if next.IsDeleted || next.IsExpired(time.Now()) {
+			return nil
+		}
+		target = next.OriginalURL
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func requireURLCode(t *testing.T, err error, code string) {
+	t.Helper()
+	var urlErr *URLError
+	require.True(t, errors.As(err, &urlErr), "want *URLError, got %v", err)
+	assert.Equal(t, code, urlErr.Code)
+}
+
+func TestShortenRejectsShortLinks(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{
+		BaseURL:        "http://localhost:8080/",
+		ShortenerHosts: []string{"bit.ly"},
+	})
+	defer svc.Close()
+
+	for _, raw := range []string{
+		"http://localhost:8080/abc123",
+		"HTTP://LOCALHOST:8080/api/user/urls",
+		"https://bit.ly/xyz",
+		"https://www.bit.ly/xyz",
+	} {
+		_, _, err := svc.Shorten(ctx, ShortenInput{OriginalURL: raw, UserID: "u1"})
+		requireURLCode(t, err, URLErrShortLink)
+	}
+
+	_, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "http://localhost:9090/abc123", UserID: "u1"})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	results, err := svc.ShortenBatch(ctx, []ShortenInput{{OriginalURL: "https://bit.ly/xyz", UserID: "u1"}})
+	require.NoError(t, err)
+	assert.Equal(t, BatchItemInvalid, results[0].Status)
+	assert.Equal(t, URLErrShortLink, results[0].Code)
+}
+
+func TestShortenResolvesShortLinks(t *testing.T) {
+	ctx := context.Background()
+
+	// Another shortener: /a -> /b -> final destination, /loop -> /loop.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "https://example.com/final?utm_source=x", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer other.Close()
+	otherURL, err := url.Parse(other.URL)
+	require.NoError(t, err)
+
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{
+		URLPolicy:       URLPolicy{StripTrackingParams: true},
+		BaseURL:         "http://short.example/",
+		ShortenerHosts:  []string{otherURL.Hostname()},
+		ShortLinkPolicy: ShortLinkResolve,
+		HTTPClient:      other.Client(),
+	})
+	defer svc.Close()
+
+	entry, _, err := svc.Shorten(ctx, ShortenInput{OriginalURL: other.URL + "/a", UserID: "u1"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/final", entry.OriginalURL)
+
+	own, created, err := svc.Shorten(ctx, ShortenInput{OriginalURL: "http://short.example/" + entry.ShortURL, UserID: "u2"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, entry.ShortURL, own.ShortURL)
+
+	_, _, err = svc.Shorten(ctx, ShortenInput{OriginalURL: other.URL + "/loop", UserID: "u1"})
+	requireURLCode(t, err, URLErrShortLink)
+	_, _, err = svc.Shorten(ctx, ShortenInput{OriginalURL: other.URL + "/not-a-redirect", UserID: "u1"})
+	requireURLCode(t, err, URLErrShortLink)
+	_, _, err = svc.Shorten(ctx, ShortenInput{OriginalURL: "http://short.example/missing", UserID: "u1"})
+	requireURLCode(t, err, URLErrShortLink)
+}
+
+func TestCheckRedirectLoop(t *testing.T) {
+	ctx := context.Background()
+	repo := store.NewInMemoryRepository()
+	svc := NewURLService(repo, zap.NewNop().Sugar(), Options{BaseURL: "http://short.example/"})
+	defer svc.Close()
+
+	// Stored directly, as links from before loop detection would be.
+	for _, u := range []store.StoredURL{
+		{ShortURL: "a", OriginalURL: "http://short.example/b"},
+		{ShortURL: "b", OriginalURL: "http://short.example/a"},
+		{ShortURL: "c", OriginalURL: "http://short.example/d"},
+		{ShortURL: "d", OriginalURL: "https://example.com/"},
+		{ShortURL: "e", OriginalURL: "http://short.example/missing"},
+	} {
+		require.NoError(t, repo.Save(ctx, u))
+	}
+
+	for id, want := range map[string]error{"a": ErrRedirectLoop, "b": ErrRedirectLoop, "c": nil, "d": nil, "e": nil} {
+		entry, err := svc.GetByShortID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, svc.CheckRedirectLoop(ctx, entry), id)
+	}
+}
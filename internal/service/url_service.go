@@ -3,30 +3,187 @@ package service
 import (
 	"context"
 	"cuturl/internal/store"
+	"errors"
+	"net/http"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+type Options struct {
+	DeleteQueueSize     int
+	DeleteBatchSize     int
+	DeleteFlushInterval time.Duration
+
+	// IDGenerator, when set, takes precedence over IDStrategy.
+	IDGenerator IDGenerator
+	IDStrategy  string
+	IDAlphabet  string
+	IDLength    int
+	IDRangeSize int
+
+	// URLPolicy decides which original URLs are accepted and how they are
+	// canonicalized; the zero value allows http and https.
+	URLPolicy URLPolicy
+
+	// SafetyCheckers are consulted, in order, before anything is saved.
+	SafetyCheckers []SafetyChecker
+
+	// BaseURL is where this shortener's own links live. Original URLs on it,
+	// or on one of ShortenerHosts, are handled by ShortLinkPolicy: reject
+	// (the default) or resolve to their final destination. HTTPClient is
+	// used to ask other shorteners where their links go.
+	BaseURL         string
+	ShortenerHosts  []string
+	ShortLinkPolicy string
+	HTTPClient      *http.Client
+}
+
+func (o Options) withDefaults() Options {
+	if o.DeleteQueueSize <= 0 {
+		o.DeleteQueueSize = 1024
+	}
+	if o.DeleteBatchSize <= 0 {
+		o.DeleteBatchSize = 500
+	}
+	if o.DeleteFlushInterval <= 0 {
+		o.DeleteFlushInterval = time.Second
+	}
+	if o.IDStrategy == "" {
+		o.IDStrategy = IDStrategyRandom
+	}
+	if o.IDAlphabet == "" {
+		o.IDAlphabet = DefaultIDAlphabet
+	}
+	if o.IDLength <= 0 {
+		o.IDLength = DefaultIDLength
+	}
+	if o.IDRangeSize <= 0 {
+		o.IDRangeSize = DefaultIDRangeSize
+	}
+	o.URLPolicy = o.URLPolicy.withDefaults()
+	return o
+}
+
 type URLService struct {
-	repo   store.Repository
-	logger *zap.SugaredLogger
+	repo    store.Repository
+	logger  *zap.SugaredLogger
+	clicks  *ClickTracker
+	deletes *deleteBatcher
+	ids     IDGenerator
+	urls    URLPolicy
+	safety  []SafetyChecker
+	links   shortLinks
+}
+
+func NewURLService(repo store.Repository, logger *zap.SugaredLogger, opts Options) *URLService {
+	opts = opts.withDefaults()
+	s := &URLService{
+		repo:    repo,
+		logger:  logger,
+		deletes: newDeleteBatcher(repo, logger, opts.DeleteQueueSize, opts.DeleteBatchSize, opts.DeleteFlushInterval),
+		ids:     opts.IDGenerator,
+		urls:    opts.URLPolicy,
+		safety:  opts.SafetyCheckers,
+		links:   newShortLinks(opts.URLPolicy, opts),
+	}
+	if s.ids == nil {
+		s.ids = newIDGenerator(repo, logger, opts)
+	}
+	if cs, ok := store.AsClickStore(repo); ok {
+		s.clicks = NewClickTracker(cs, logger)
+	}
+	return s
+}
+
+// newIDGenerator builds the configured generator, falling back to random IDs
+// if the options are invalid. The sequential counter starts after the links
+// already stored; collisions with aliases are retried like any other. The
+// range strategy leases blocks from the repository when it supports that.
+func newIDGenerator(repo store.Repository, logger *zap.SugaredLogger, opts Options) IDGenerator {
+	if opts.IDStrategy == IDStrategyRange {
+		leaser, ok := store.AsIDRangeLeaser(repo)
+		if !ok {
+			logger.Warn("repository cannot lease ID ranges, using a process-local counter")
+			leaser = &localRangeLeaser{}
+		}
+		ids, err := NewRangeIDGenerator(leaser, opts.IDAlphabet, opts.IDLength, uint64(opts.IDRangeSize))
+		if err == nil {
+			return ids
+		}
+		logger.Errorw("invalid ID generator options, using random IDs", "error", err)
+		opts.IDStrategy = IDStrategyRandom
+	}
+
+	var next uint64
+	if opts.IDStrategy == IDStrategySequential {
+		n, err := countURLs(context.Background(), repo)
+		if err != nil {
+			logger.Errorw("failed to count stored URLs for sequential IDs", "error", err)
+		}
+		next = uint64(n)
+	}
+	ids, err := NewIDGenerator(opts.IDStrategy, opts.IDAlphabet, opts.IDLength, next)
+	if err != nil {
+		logger.Errorw("invalid ID generator options, using random IDs", "error", err)
+		ids, _ = NewIDGenerator(IDStrategyRandom, DefaultIDAlphabet, DefaultIDLength, 0)
+	}
+	return ids
 }
 
-func NewURLService(repo store.Repository, logger *zap.SugaredLogger) *URLService {
-	return &URLService{repo: repo, logger: logger}
+// countURLs asks the repository for a count where it can give one, and
+// loads everything otherwise.
+func countURLs(ctx context.Context, repo store.Repository) (int64, error) {
+	if counter, ok := store.AsURLCounter(repo); ok {
+		return counter.CountURLs(ctx)
+	}
+	urls, err := repo.Load(ctx)
+	return int64(len(urls)), err
+}
+
+// Close drains pending deletions and buffered clicks. Calls to
+// MarkDeletedAsync made after Close fail with ErrShuttingDown.
+func (s *URLService) Close() {
+	s.Shutdown(context.Background())
+}
+
+// Shutdown is Close bounded by ctx: deletions and clicks still pending when
+// it is done are abandoned, and its error is returned.
+func (s *URLService) Shutdown(ctx context.Context) error {
+	err := s.deletes.close(ctx)
+	if s.clicks != nil {
+		err = errors.Join(err, s.clicks.Shutdown(ctx))
+	}
+	return err
 }
 
 func (s *URLService) SaveURL(ctx context.Context, url store.StoredURL) error {
-	return s.repo.Save(url)
+	if err := s.ensureSafe(ctx, url.OriginalURL); err != nil {
+		return err
+	}
+	return s.repo.Save(ctx, url)
+}
+
+func (s *URLService) SaveAliasedURL(ctx context.Context, url store.StoredURL) error {
+	if err := ValidateAlias(url.ShortURL); err != nil {
+		return err
+	}
+	if err := s.ensureSafe(ctx, url.OriginalURL); err != nil {
+		return err
+	}
+	err := s.repo.Save(ctx, url)
+	if errors.Is(err, store.ErrShortURLConflict) {
+		return ErrAliasTaken
+	}
+	return err
 }
 
 func (s *URLService) GetByShortID(ctx context.Context, id string) (*store.StoredURL, error) {
-	return s.repo.FindByShortID(id)
+	return s.repo.FindByShortID(ctx, id)
 }
 
-func (s *URLService) GetByOriginalURL(ctx context.Context, url string) (*store.StoredURL, error) {
-	return s.repo.FindByOriginalURL(url)
+func (s *URLService) GetByOriginalURL(ctx context.Context, userID, url string) (*store.StoredURL, error) {
+	return s.repo.FindByOriginalURL(ctx, userID, url)
 }
 
 func (s *URLService) GetUserURLs(ctx context.Context, userID string) ([]store.StoredURL, error) {
@@ -37,17 +194,47 @@ func (s *URLService) MarkDeleted(ctx context.Context, userID string, ids []strin
 	return s.repo.MarkDeleted(ctx, userID, ids)
 }
 
-func (s *URLService) BatchSave(ctx context.Context, urls []store.StoredURL) error {
+// BatchSave saves nothing if any of urls is blocked.
+func (s *URLService) BatchSave(ctx context.Context, urls []store.StoredURL) ([]store.BatchResult, error) {
+	for _, u := range urls {
+		if err := s.ensureSafe(ctx, u.OriginalURL); err != nil {
+			return nil, err
+		}
+	}
 	return s.repo.BatchSave(ctx, urls)
 }
 
-func (s *URLService) MarkDeletedAsync(userID string, ids []string) {
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+// MarkDeletedAsync queues the deletion and returns once it is accepted. It
+// blocks while the queue is full, until ctx is done.
+func (s *URLService) MarkDeletedAsync(ctx context.Context, userID string, ids []string) error {
+	return s.deletes.submit(ctx, store.DeleteRequest{UserID: userID, ShortIDs: ids})
+}
 
-		if err := s.repo.MarkDeleted(ctx, userID, ids); err != nil {
-			s.logger.Errorf("failed to mark deleted: %v", err)
-		}
-	}()
+func (s *URLService) DeleteQueueDepth() int {
+	return s.deletes.depth()
+}
+
+func (s *URLService) RecordClick(e store.ClickEvent) {
+	if s.clicks != nil {
+		s.clicks.Track(e)
+	}
+}
+
+func (s *URLService) GetLinkStats(ctx context.Context, userID string, shortID string) (*store.LinkStats, error) {
+	if s.clicks == nil {
+		return nil, ErrStatsNotAvailable
+	}
+
+	entry, err := s.repo.FindByShortID(ctx, shortID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.UserID != userID {
+		return nil, ErrNotOwner
+	}
+
+	return s.clicks.store.GetLinkStats(ctx, shortID)
 }
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// APIKey is a long-lived credential for a user. Only a hash of the secret is
+// stored; the secret itself is shown once, when the key is created.
+type APIKey struct {
+	ID     string `json:"id" db:"id"`
+	UserID string `json:"user_id" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+	// Hash is the hex-encoded SHA-256 of the secret.
+	Hash       string     `json:"hash" db:"key_hash"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Usable reports whether the key may still authenticate requests at now.
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyStore keeps API keys. Lookups by hash and by user return ErrNotFound
+// and an empty slice respectively when nothing matches.
+type APIKeyStore interface {
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error)
+	// RevokeAPIKey returns ErrNotFound unless userID owns the key. Revoking
+	// a revoked key keeps the original time.
+	RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// The helpers below implement APIKeyStore over a map of keys by ID, for the
+// memory and file backends.
+
+func checkNewAPIKey(keys map[string]APIKey, key APIKey) error {
+	for _, k := range keys {
+		if k.ID == key.ID || k.Hash == key.Hash {
+			return ErrUniqueViolation
+		}
+	}
+	return nil
+}
+
+func findAPIKeyByHash(keys map[string]APIKey, hash string) (*APIKey, error) {
+	for _, k := range keys {
+		if k.Hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// apiKeysOf returns userID's keys, oldest first.
+func apiKeysOf(keys map[string]APIKey, userID string) []APIKey {
+	result := []APIKey{}
+	for _, k := range keys {
+		if k.UserID == userID {
+			result = append(result, k)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func revokeAPIKey(keys map[string]APIKey, userID, id string, at time.Time) error {
+	k, ok := keys[id]
+	if !ok || k.UserID != userID {
+		return ErrNotFound
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		keys[id] = k
+	}
+	return nil
+}
+
+func touchAPIKey(keys map[string]APIKey, id string, at time.Time) error {
+	k, ok := keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	k.LastUsedAt = &at
+	keys[id] = k
+	return nil
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	errClicksUnsupported = errors.New("backend does not store clicks")
+	errRangesUnsupported = errors.New("backend does not lease id ranges")
+	errKeysUnsupported   = errors.New("backend does not store api keys")
+	errPagingUnsupported = errors.New("backend does not page through urls")
+	errCountUnsupported  = errors.New("backend does not count urls")
+)
+
+// CacheStats counts FindByShortID lookups since the cache was created.
+// Negative hits (a cached "not found") count as hits.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheEntry struct {
+	key     string
+	url     *StoredURL // nil caches ErrNotFound
+	expires time.Time
+}
+
+// CachedRepository is a read-through cache for FindByShortID in front of any
+// Repository. It keeps up to size entries in LRU order, including misses,
+// for at most ttl. Writes through this repository invalidate the short IDs
+// they touch; writes made elsewhere (another replica) show up after ttl.
+// Concurrent misses for the same short ID share one backend lookup.
+type CachedRepository struct {
+	next Repository
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// gen is bumped on every invalidation, so a lookup that raced with a
+	// write doesn't store the value it read before the write.
+	gen uint64
+
+	group  singleflight.Group
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewCachedRepository(next Repository, size int, ttl time.Duration) *CachedRepository {
+	return &CachedRepository{
+		next:    next,
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *CachedRepository) Unwrap() Repository {
+	return c.next
+}
+
+func (c *CachedRepository) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+func (c *CachedRepository) get(key string, now time.Time) (*StoredURL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if now.After(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry.url, true
+}
+
+func (c *CachedRepository) put(key string, url *StoredURL, gen uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, url: url, expires: now.Add(c.ttl)}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, url: url, expires: now.Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *CachedRepository) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *CachedRepository) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// copyURL hands callers their own copy, so they can't modify cached values.
+func copyURL(u *StoredURL) *StoredURL {
+	if u == nil {
+		return nil
+	}
+	cp := *u
+	return &cp
+}
+
+// cacheLookupTimeout bounds a shared backend lookup, which runs detached from
+// the request that started it.
+const cacheLookupTimeout = 10 * time.Second
+
+// FindByShortID shares a miss's backend lookup with concurrent callers. The
+// lookup doesn't inherit the first caller's cancellation, so one client
+// going away doesn't fail the others; each caller still stops waiting when
+// its own ctx is done.
+func (c *CachedRepository) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
+	if url, ok := c.get(id, time.Now()); ok {
+		c.hits.Add(1)
+		if url == nil {
+			return nil, ErrNotFound
+		}
+		return copyURL(url), nil
+	}
+	c.misses.Add(1)
+
+	lookup := c.group.DoChan(id, func() (any, error) {
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheLookupTimeout)
+		defer cancel()
+		url, err := c.next.FindByShortID(lookupCtx, id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		c.put(id, url, gen, time.Now())
+		return url, err
+	})
+	select {
+	case res := <-lookup:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return copyURL(res.Val.(*StoredURL)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *CachedRepository) Load(ctx context.Context) ([]StoredURL, error) {
+	return c.next.Load(ctx)
+}
+
+func (c *CachedRepository) Save(ctx context.Context, entry StoredURL) error {
+	defer c.invalidate(entry.ShortURL)
+	return c.next.Save(ctx, entry)
+}
+
+func (c *CachedRepository) Ping(ctx context.Context) error {
+	return c.next.Ping(ctx)
+}
+
+func (c *CachedRepository) FindByOriginalURL(ctx context.Context, userID, orig string) (*StoredURL, error) {
+	return c.next.FindByOriginalURL(ctx, userID, orig)
+}
+
+func (c *CachedRepository) BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error) {
+	keys := make([]string, 0, len(urls))
+	for _, u := range urls {
+		keys = append(keys, u.ShortURL)
+	}
+	defer c.invalidate(keys...)
+	return c.next.BatchSave(ctx, urls)
+}
+
+func (c *CachedRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
+	return c.next.GetURLsByUserID(ctx, userID)
+}
+
+func (c *CachedRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
+	defer c.invalidate(ids...)
+	return c.next.MarkDeleted(ctx, userID, ids)
+}
+
+func (c *CachedRepository) MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error {
+	var keys []string
+	for _, req := range reqs {
+		keys = append(keys, req.ShortIDs...)
+	}
+	defer c.invalidate(keys...)
+	return c.next.MarkDeletedBatch(ctx, reqs)
+}
+
+// ExpireURLs drops the whole cache when anything expired; it doesn't know
+// which short IDs were affected.
+func (c *CachedRepository) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	n, err := c.next.ExpireURLs(ctx, now)
+	if n > 0 {
+		c.purge()
+	}
+	return n, err
+}
+
+func (c *CachedRepository) SaveClicks(ctx context.Context, clicks []ClickEvent) error {
+	cs, ok := c.next.(ClickStore)
+	if !ok {
+		return errClicksUnsupported
+	}
+	return cs.SaveClicks(ctx, clicks)
+}
+
+func (c *CachedRepository) GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error) {
+	cs, ok := c.next.(ClickStore)
+	if !ok {
+		return nil, errClicksUnsupported
+	}
+	return cs.GetLinkStats(ctx, shortID)
+}
+
+func (c *CachedRepository) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	leaser, ok := c.next.(IDRangeLeaser)
+	if !ok {
+		return 0, errRangesUnsupported
+	}
+	return leaser.LeaseIDRange(ctx, size)
+}
+
+func (c *CachedRepository) SaveAPIKey(ctx context.Context, key APIKey) error {
+	ks, ok := c.next.(APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.SaveAPIKey(ctx, key)
+}
+
+func (c *CachedRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	ks, ok := c.next.(APIKeyStore)
+	if !ok {
+		return nil, errKeysUnsupported
+	}
+	return ks.FindAPIKeyByHash(ctx, hash)
+}
+
+func (c *CachedRepository) GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	ks, ok := c.next.(APIKeyStore)
+	if !ok {
+		return nil, errKeysUnsupported
+	}
+	return ks.GetAPIKeysByUserID(ctx, userID)
+}
+
+func (c *CachedRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	ks, ok := c.next.(APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.RevokeAPIKey(ctx, userID, id, at)
+}
+
+func (c *CachedRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	ks, ok := c.next.(APIKeyStore)
+	if !ok {
+		return errKeysUnsupported
+	}
+	return ks.TouchAPIKey(ctx, id, at)
+}
+
+func (c *CachedRepository) ListURLsAfter(ctx context.Context, after string, limit int) ([]StoredURL, error) {
+	pager, ok := c.next.(URLPager)
+	if !ok {
+		return nil, errPagingUnsupported
+	}
+	return pager.ListURLsAfter(ctx, after, limit)
+}
+
+func (c *CachedRepository) CountURLs(ctx context.Context) (int64, error) {
+	counter, ok := c.next.(URLCounter)
+	if !ok {
+		return 0, errCountUnsupported
+	}
+	return counter.CountURLs(ctx)
+}
+
+func (c *CachedRepository) Close() error {
+	if closer, ok := c.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
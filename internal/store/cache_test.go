@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingFinder struct {
+	*InMemoryRepository
+	finds   atomic.Int32
+	release chan struct{}
+}
+
+func (r *countingFinder) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
+	r.finds.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.InMemoryRepository.FindByShortID(ctx, id)
+}
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingFinder{InMemoryRepository: NewInMemoryRepository()}
+	repo := NewCachedRepository(backend, 2, time.Minute)
+
+	require.NoError(t, backend.Save(ctx, StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://a.example", UserID: "u1"}))
+
+	for i := 0; i < 3; i++ {
+		entry, err := repo.FindByShortID(ctx, "aaa")
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+	}
+	assert.Equal(t, int32(1), backend.finds.Load())
+
+	// Misses are cached too, until a Save through the cache creates the ID.
+	for i := 0; i < 2; i++ {
+		_, err := repo.FindByShortID(ctx, "bbb")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, int32(2), backend.finds.Load())
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "bbb", ShortURL: "bbb", OriginalURL: "https://b.example", UserID: "u1"}))
+	entry, err := repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+
+	// Deleting invalidates, so the redirect sees the tombstone.
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"aaa"}))
+	entry, err = repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+
+	// Callers can't change what the cache holds.
+	entry.OriginalURL = "https://evil.example"
+	entry, err = repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", entry.OriginalURL)
+
+	// Capacity is two: looking up a third ID evicts the least recently used.
+	_, err = repo.FindByShortID(ctx, "ccc")
+	require.ErrorIs(t, err, ErrNotFound)
+	before := backend.finds.Load()
+	_, err = repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	assert.Equal(t, before+1, backend.finds.Load())
+
+	stats := repo.Stats()
+	assert.Equal(t, uint64(4), stats.Hits)
+	assert.Equal(t, uint64(6), stats.Misses)
+}
+
+func TestCachedRepositoryExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingFinder{InMemoryRepository: NewInMemoryRepository()}
+	repo := NewCachedRepository(backend, 10, time.Millisecond)
+
+	_, err := repo.FindByShortID(ctx, "aaa")
+	require.ErrorIs(t, err, ErrNotFound)
+	time.Sleep(5 * time.Millisecond)
+	_, err = repo.FindByShortID(ctx, "aaa")
+	require.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int32(2), backend.finds.Load())
+}
+
+func TestCachedRepositoryCollapsesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingFinder{InMemoryRepository: NewInMemoryRepository(), release: make(chan struct{})}
+	repo := NewCachedRepository(backend, 10, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.FindByShortID(ctx, "aaa")
+			assert.ErrorIs(t, err, ErrNotFound)
+		}()
+	}
+	require.Eventually(t, func() bool { return repo.Stats().Misses == 8 }, time.Second, time.Millisecond)
+	// Give the last goroutine time to join the in-flight lookup.
+	time.Sleep(10 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), backend.finds.Load())
+}
+
+func TestCachedRepositoryLookupOutlivesFirstCaller(t *testing.T) {
+	backend := &countingFinder{InMemoryRepository: NewInMemoryRepository(), release: make(chan struct{})}
+	repo := NewCachedRepository(backend, 10, time.Minute)
+	require.NoError(t, backend.Save(context.Background(), StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://a.example"}))
+
+	// The first caller starts the shared lookup, then goes away.
+	gone, cancel := context.WithCancel(context.Background())
+	goneErr := make(chan error, 1)
+	go func() {
+		_, err := repo.FindByShortID(gone, "aaa")
+		goneErr <- err
+	}()
+	require.Eventually(t, func() bool { return backend.finds.Load() == 1 }, time.Second, time.Millisecond)
+
+	live := make(chan error, 1)
+	go func() {
+		entry, err := repo.FindByShortID(context.Background(), "aaa")
+		if err == nil && entry.OriginalURL != "https://a.example" {
+			err = assert.AnError
+		}
+		live <- err
+	}()
+	require.Eventually(t, func() bool { return repo.Stats().Misses == 2 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-goneErr, context.Canceled)
+	close(backend.release)
+	assert.NoError(t, <-live)
+	assert.Equal(t, int32(1), backend.finds.Load())
+}
+
+// plainRepo hides every optional capability of the repository it embeds.
+type plainRepo struct {
+	Repository
+}
+
+func TestCachedRepositoryCapabilities(t *testing.T) {
+	full := NewCachedRepository(NewCachedRepository(NewInMemoryRepository(), 10, time.Minute), 10, time.Minute)
+	_, ok := AsClickStore(full)
+	assert.True(t, ok)
+	_, ok = AsIDRangeLeaser(full)
+	assert.True(t, ok)
+	_, ok = AsAPIKeyStore(full)
+	assert.True(t, ok)
+
+	// The wrapper has the methods, but not what they forward to.
+	plain := NewCachedRepository(plainRepo{NewInMemoryRepository()}, 10, time.Minute)
+	var _ ClickStore = plain
+	_, ok = AsClickStore(plain)
+	assert.False(t, ok)
+	_, ok = AsIDRangeLeaser(plain)
+	assert.False(t, ok)
+	_, ok = AsAPIKeyStore(plain)
+	assert.False(t, ok)
+}
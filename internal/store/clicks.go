@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type ClickEvent struct {
+	ShortURL  string    `json:"short_url" db:"short_url"`
+	ClickedAt time.Time `json:"clicked_at" db:"clicked_at"`
+	Referrer  string    `json:"referrer" db:"referrer"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	IPBucket  string    `json:"ip_bucket" db:"ip_bucket"`
+}
+
+type DailyClicks struct {
+	Date   string `json:"date" db:"day"`
+	Clicks int64  `json:"clicks" db:"clicks"`
+}
+
+type LinkStats struct {
+	TotalClicks    int64         `json:"total_clicks"`
+	UniqueVisitors int64         `json:"unique_visitors"`
+	Daily          []DailyClicks `json:"daily"`
+}
+
+type ClickStore interface {
+	SaveClicks(ctx context.Context, clicks []ClickEvent) error
+	GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error)
+}
+
+const statsDateLayout = "2006-01-02"
+
+// A visitor is identified by the coarse IP bucket together with the user agent.
+func visitorKey(c ClickEvent) string {
+	return c.IPBucket + "|" + c.UserAgent
+}
+
+func aggregateClicks(clicks []ClickEvent) *LinkStats {
+	stats := &LinkStats{Daily: []DailyClicks{}}
+	visitors := make(map[string]struct{})
+	perDay := make(map[string]int64)
+
+	for _, c := range clicks {
+		stats.TotalClicks++
+		visitors[visitorKey(c)] = struct{}{}
+		perDay[c.ClickedAt.UTC().Format(statsDateLayout)]++
+	}
+	stats.UniqueVisitors = int64(len(visitors))
+
+	for day, n := range perDay {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: day, Clicks: n})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	return stats
+}
@@ -0,0 +1,44 @@
+//go:build postgres
+
+package store_test
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"cuturl/internal/store/storetest"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// Run with: TEST_DATABASE_DSN=postgres://... go test -tags postgres ./internal/store/
+// The tables in that database are reset before every subtest.
+func TestPostgresRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	storetest.Run(t, func(t *testing.T, opts ...store.Option) store.Repository {
+		repo, err := store.NewPostgresRepository(ctx, dsn, opts...)
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "TRUNCATE urls, clicks, api_keys")
+		require.NoError(t, err)
+		// id_ranges keeps its seed row; only the counter is reset.
+		_, err = db.ExecContext(ctx, "UPDATE id_ranges SET next_id = 0")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			if closer, ok := repo.(interface{ Close() error }); ok {
+				closer.Close()
+			}
+		})
+		return repo
+	})
+}
@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"cuturl/internal/store/storetest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...store.Option) store.Repository {
+		return store.NewInMemoryRepository(opts...)
+	})
+}
+
+func TestFileRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...store.Option) store.Repository {
+		return store.NewFileRepository(filepath.Join(t.TempDir(), "urls.json"), opts...)
+	})
+}
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...store.Option) store.Repository {
+		repo, err := store.NewSQLiteRepository(context.Background(), filepath.Join(t.TempDir(), "urls.db"), opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
+
+func TestCachedRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...store.Option) store.Repository {
+		return store.NewCachedRepository(store.NewInMemoryRepository(opts...), 100, time.Minute)
+	})
+}
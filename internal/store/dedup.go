@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// DedupScope decides which links count as the same original URL. Shortening
+// a URL that is already stored within the scope returns the existing link
+// (ErrUniqueViolation from Save, BatchExists from BatchSave).
+type DedupScope string
+
+const (
+	// DedupGlobal keeps one link per original URL across all users.
+	DedupGlobal DedupScope = "global"
+	// DedupPerUser keeps one link per original URL for each user.
+	DedupPerUser DedupScope = "user"
+	// DedupNone creates a new link every time.
+	DedupNone DedupScope = "none"
+)
+
+func ParseDedupScope(s string) (DedupScope, error) {
+	switch scope := DedupScope(s); scope {
+	case DedupGlobal, DedupPerUser, DedupNone:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown dedup scope %q: want global, user or none", s)
+}
+
+// key identifies originalURL within the scope; ok is false when the
+// scope never deduplicates.
+func (s DedupScope) key(userID, originalURL string) (key string, ok bool) {
+	switch s {
+	case DedupPerUser:
+		return userID + "\x00" + originalURL, true
+	case DedupNone:
+		return "", false
+	}
+	return originalURL, true
+}
+
+type Option func(*options)
+
+type options struct {
+	dedup  DedupScope
+	logger *zap.SugaredLogger
+}
+
+// WithDedupScope sets the dedup scope; the default is DedupGlobal.
+func WithDedupScope(scope DedupScope) Option {
+	return func(o *options) {
+		o.dedup = scope
+	}
+}
+
+// WithLogger sets where a repository reports problems it recovers from,
+// such as corrupt records in a storage file; the default discards them.
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{dedup: DedupGlobal, logger: zap.NewNop().Sugar()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.dedup == "" {
+		o.dedup = DedupGlobal
+	}
+	return o
+}
+
+// The SQL backends enforce the scope with one of these unique indexes, which
+// are created or dropped on startup to match the configured scope. Switching
+// to a narrower scope fails while existing links still violate it.
+const (
+	urlsOriginalURLKey     = "urls_original_url_key"
+	urlsUserOriginalURLKey = "urls_user_original_url_key"
+)
+
+func (s DedupScope) indexStatements() []string {
+	drop := func(name string) string { return "DROP INDEX IF EXISTS " + name }
+	switch s {
+	case DedupPerUser:
+		return []string{
+			drop(urlsOriginalURLKey),
+			"CREATE UNIQUE INDEX IF NOT EXISTS " + urlsUserOriginalURLKey + " ON urls (COALESCE(user_id, ''), original_url)",
+		}
+	case DedupNone:
+		return []string{drop(urlsOriginalURLKey), drop(urlsUserOriginalURLKey)}
+	}
+	return []string{
+		drop(urlsUserOriginalURLKey),
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + urlsOriginalURLKey + " ON urls (original_url)",
+	}
+}
+
+func applyDedupScope(ctx context.Context, db *sqlx.DB, scope DedupScope) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range scope.indexStatements() {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to apply dedup scope %q (stored links may already violate it): %w", scope, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// where selects the link a new one for userID and originalURL would be
+// deduplicated to; ok is false when the scope never deduplicates.
+func (s DedupScope) where(userID, originalURL string) (cond sq.Sqlizer, ok bool) {
+	switch s {
+	case DedupPerUser:
+		return sq.And{
+			sq.Eq{"original_url": originalURL},
+			sq.Expr("COALESCE(user_id, '') = ?", userID),
+		}, true
+	case DedupNone:
+		return nil, false
+	}
+	return sq.Eq{"original_url": originalURL}, true
+}
@@ -5,3 +5,7 @@ import (
 )
 
 var ErrUniqueViolation = errors.New("unique violation")
+var ErrShortURLConflict = errors.New("short url already exists")
+
+// ErrNotFound is returned by the FindBy methods when no link matches.
+var ErrNotFound = errors.New("not found")
@@ -2,84 +2,327 @@ package store
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 type Repository interface {
-	Load() ([]StoredURL, error)
-	Save(entry StoredURL) error
-	Ping() error
-	FindByShortID(id string) (*StoredURL, error)
-	FindByOriginalURL(orig string) (*StoredURL, error)
-	BatchSave(ctx context.Context, urls []StoredURL) error
+	Load(ctx context.Context) ([]StoredURL, error)
+	Save(ctx context.Context, entry StoredURL) error
+	Ping(ctx context.Context) error
+	FindByShortID(ctx context.Context, id string) (*StoredURL, error)
+	// FindByOriginalURL returns the link that shortening orig for userID
+	// would be deduplicated to under the repository's DedupScope.
+	FindByOriginalURL(ctx context.Context, userID, orig string) (*StoredURL, error)
+	BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error)
 	GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error)
 	MarkDeleted(ctx context.Context, userID string, ids []string) error
+	MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error
+	ExpireURLs(ctx context.Context, now time.Time) (int64, error)
 }
 
-type FileRepository struct {
-	Path      string
-	urlsMutex *sync.Mutex
+type StoredURL struct {
+	UUID        string     `json:"uuid" db:"uuid"`
+	ShortURL    string     `json:"short_url" db:"short_url"`
+	OriginalURL string     `json:"original_url" db:"original_url"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	IsDeleted   bool       `json:"is_deleted" db:"is_deleted"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
 }
 
-type StoredURL struct {
-	UUID        string `json:"uuid" db:"uuid"`
-	ShortURL    string `json:"short_url" db:"short_url"`
-	OriginalURL string `json:"original_url" db:"original_url"`
-	UserID      string `json:"user_id" db:"user_id"`
-	IsDeleted   bool   `json:"is_deleted" db:"is_deleted"`
+type BatchStatus string
+
+const (
+	BatchCreated  BatchStatus = "created"
+	BatchExists   BatchStatus = "exists"
+	BatchConflict BatchStatus = "conflict"
+)
+
+// BatchResult is the outcome of one BatchSave entry. BatchExists means the
+// original URL was already stored within the dedup scope (Existing holds that
+// entry); BatchConflict means the short ID is taken by another URL. Only
+// created entries are saved.
+type BatchResult struct {
+	Status   BatchStatus
+	Existing *StoredURL
 }
 
-func NewFileRepository(path string) *FileRepository {
-	return &FileRepository{Path: path, urlsMutex: &sync.Mutex{}}
+// DeleteRequest is one user's share of a coalesced deletion batch.
+type DeleteRequest struct {
+	UserID   string
+	ShortIDs []string
 }
 
-func (fr *FileRepository) Load() ([]StoredURL, error) {
-	fr.urlsMutex.Lock()
-	defer fr.urlsMutex.Unlock()
+func (u StoredURL) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// The file is an append-only journal of JSON lines. A plain StoredURL line
+// (the only kind older versions wrote) inserts or replaces the entry with the
+// same short_url; a line with "op":"delete" is a tombstone. The whole journal
+// is replayed into an in-memory index on first use and rewritten only when
+// compaction kicks in: after a write once at least half the records are
+// superseded, and from RunCompactor whenever any are.
+//
+// A crash can leave the last line half written. Loading drops such a line
+// (or terminates it, if it is complete JSON) so that the next append starts
+// on a line of its own; other unreadable lines are logged and skipped.
+const (
+	opDelete = "delete"
+
+	compactMinRecords = 1024
+	compactRatio      = 2
+)
+
+type journalRecord struct {
+	StoredURL
+	Op string `json:"op,omitempty"`
+}
+
+type tombstone struct {
+	Op       string `json:"op"`
+	ShortURL string `json:"short_url"`
+	UserID   string `json:"user_id"`
+}
+
+type fileEntry struct {
+	url StoredURL
+	seq int
+}
+
+type FileRepository struct {
+	Path        string
+	urlsMutex   *sync.Mutex
+	clicksMutex *sync.Mutex
+	idsMutex    *sync.Mutex
+	keysMutex   *sync.Mutex
+	dedup       DedupScope
+	logger      *zap.SugaredLogger
+
+	loaded    bool
+	nextSeq   int
+	records   int
+	byShortID map[string]*fileEntry
+	// byOriginal maps dedup keys (see DedupScope.key) to short IDs.
+	byOriginal map[string]string
+	byUser     map[string]map[string]struct{}
+
+	// keys mirrors the API key sidecar file once it has been read.
+	keys map[string]APIKey
+}
 
-	file, err := os.OpenFile(fr.Path, os.O_RDONLY|os.O_CREATE, 0666)
+func NewFileRepository(path string, opts ...Option) *FileRepository {
+	o := newOptions(opts)
+	return &FileRepository{
+		Path:        path,
+		urlsMutex:   &sync.Mutex{},
+		clicksMutex: &sync.Mutex{},
+		idsMutex:    &sync.Mutex{},
+		keysMutex:   &sync.Mutex{},
+		dedup:       o.dedup,
+		logger:      o.logger,
+	}
+}
+
+func (fr *FileRepository) ensureLoaded() error {
+	if fr.loaded {
+		return nil
+	}
+
+	file, err := os.OpenFile(fr.Path, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
-	var urls []StoredURL
-	scanner := bufio.NewScanner(file)
 
-	for scanner.Scan() {
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			urls = append(urls, entry)
+	fr.nextSeq = 0
+	fr.records = 0
+	fr.byShortID = make(map[string]*fileEntry)
+	fr.byOriginal = make(map[string]string)
+	fr.byUser = make(map[string]map[string]struct{})
+
+	// Lines are read whole, however long, so one oversized record can't make
+	// the rest of the file unreadable.
+	r := bufio.NewReader(file)
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
 		}
+		terminated := err == nil
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec journalRecord
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr == nil {
+				fr.records++
+				fr.apply(rec)
+				if !terminated {
+					if _, err := file.WriteAt([]byte("\n"), offset+int64(len(line))); err != nil {
+						return err
+					}
+				}
+			} else if terminated {
+				// Counted, so compaction gets rid of it.
+				fr.records++
+				fr.logger.Warnw("skipping unreadable record in storage file", "path", fr.Path, "line", lineNo, "error", jsonErr)
+			} else {
+				fr.logger.Warnw("dropping torn last record in storage file", "path", fr.Path, "line", lineNo, "error", jsonErr)
+				if err := file.Truncate(offset); err != nil {
+					return err
+				}
+			}
+		}
+		if !terminated {
+			break
+		}
+		offset += int64(len(line))
 	}
-	return urls, scanner.Err()
+
+	fr.loaded = true
+	return nil
 }
 
-func (fr *FileRepository) Save(entry StoredURL) error {
-	fr.urlsMutex.Lock()
-	defer fr.urlsMutex.Unlock()
+func (fr *FileRepository) apply(rec journalRecord) {
+	if rec.Op == opDelete {
+		if e, ok := fr.byShortID[rec.ShortURL]; ok {
+			e.url.IsDeleted = true
+		}
+		return
+	}
+	fr.index(rec.StoredURL)
+}
 
-	file, err := os.OpenFile(fr.Path, os.O_RDONLY|os.O_CREATE, 0666)
+func (fr *FileRepository) index(u StoredURL) {
+	if old, ok := fr.byShortID[u.ShortURL]; ok {
+		if key, ok := fr.dedup.key(old.url.UserID, old.url.OriginalURL); ok && fr.byOriginal[key] == u.ShortURL {
+			delete(fr.byOriginal, key)
+		}
+		delete(fr.byUser[old.url.UserID], u.ShortURL)
+		old.url = u
+	} else {
+		fr.byShortID[u.ShortURL] = &fileEntry{url: u, seq: fr.nextSeq}
+		fr.nextSeq++
+	}
+
+	if key, ok := fr.dedup.key(u.UserID, u.OriginalURL); ok {
+		if _, taken := fr.byOriginal[key]; !taken {
+			fr.byOriginal[key] = u.ShortURL
+		}
+	}
+	if fr.byUser[u.UserID] == nil {
+		fr.byUser[u.UserID] = make(map[string]struct{})
+	}
+	fr.byUser[u.UserID][u.ShortURL] = struct{}{}
+}
+
+func (fr *FileRepository) appendRecords(records []any) error {
+	file, err := os.OpenFile(fr.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var urls []StoredURL
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var e StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
-			urls = append(urls, e)
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			file.Close()
+			return err
 		}
 	}
-	if err := scanner.Err(); err != nil {
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
-	urls = append(urls, entry)
+	fr.records += len(records)
+	return nil
+}
+
+func (fr *FileRepository) sortedEntries(shortIDs map[string]struct{}) []StoredURL {
+	entries := make([]*fileEntry, 0, len(shortIDs))
+	for id := range shortIDs {
+		entries = append(entries, fr.byShortID[id])
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	result := make([]StoredURL, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.url)
+	}
+	return result
+}
+
+func (fr *FileRepository) all() []StoredURL {
+	ids := make(map[string]struct{}, len(fr.byShortID))
+	for id := range fr.byShortID {
+		ids[id] = struct{}{}
+	}
+	return fr.sortedEntries(ids)
+}
+
+func (fr *FileRepository) maybeCompact() error {
+	if fr.records < compactMinRecords || fr.records < compactRatio*len(fr.byShortID) {
+		return nil
+	}
+	return fr.compact()
+}
+
+// RunCompactor compacts the journal every interval while it holds superseded
+// records, until ctx is done. Failures are logged; the journal stays usable.
+func (fr *FileRepository) RunCompactor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fr.compactIfStale(); err != nil {
+				fr.logger.Errorw("failed to compact storage file", "path", fr.Path, "error", err)
+			}
+		}
+	}
+}
+
+func (fr *FileRepository) compactIfStale() error {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	if !fr.loaded || fr.records <= len(fr.byShortID) {
+		return nil
+	}
+	return fr.compact()
+}
+
+// Compact rewrites the journal so that it holds exactly one line per link.
+func (fr *FileRepository) Compact() error {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	if err := fr.ensureLoaded(); err != nil {
+		return err
+	}
+	return fr.compact()
+}
+
+func (fr *FileRepository) compact() error {
+	live := fr.all()
 
 	tmpPath := fr.Path + ".tmp"
 	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
@@ -87,75 +330,215 @@ func (fr *FileRepository) Save(entry StoredURL) error {
 		return err
 	}
 
-	enc := json.NewEncoder(tmpFile)
-	for _, u := range urls {
+	w := bufio.NewWriter(tmpFile)
+	enc := json.NewEncoder(w)
+	for _, u := range live {
 		if err := enc.Encode(u); err != nil {
 			tmpFile.Close()
 			return err
 		}
 	}
+	if err := w.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
 	if err := tmpFile.Close(); err != nil {
 		return err
 	}
 
-	return os.Rename(tmpPath, fr.Path)
+	if err := os.Rename(tmpPath, fr.Path); err != nil {
+		return err
+	}
+	fr.records = len(live)
+	return nil
 }
 
-func (fr *FileRepository) Ping() error {
-	return nil
+func (fr *FileRepository) Load(ctx context.Context) ([]StoredURL, error) {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if err := fr.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	return fr.all(), nil
 }
 
-func (fr *FileRepository) FindByShortID(id string) (*StoredURL, error) {
+func (fr *FileRepository) Save(ctx context.Context, entry StoredURL) error {
 	fr.urlsMutex.Lock()
 	defer fr.urlsMutex.Unlock()
 
-	file, err := os.Open(fr.Path)
-	if err != nil {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if err := fr.ensureLoaded(); err != nil {
+		return err
+	}
+	if _, ok := fr.byShortID[entry.ShortURL]; ok {
+		return ErrShortURLConflict
+	}
+	if fr.findByOriginalURL(entry.UserID, entry.OriginalURL) != nil {
+		return ErrUniqueViolation
+	}
+
+	if err := fr.appendRecords([]any{entry}); err != nil {
+		return err
+	}
+	fr.index(entry)
+	return fr.maybeCompact()
+}
+
+func (fr *FileRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (fr *FileRepository) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if err := fr.ensureLoaded(); err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	if e, ok := fr.byShortID[id]; ok {
+		u := e.url
+		return &u, nil
+	}
+	return nil, ErrNotFound
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			if entry.ShortURL == id {
-				return &entry, nil
-			}
-		}
+func (fr *FileRepository) FindByOriginalURL(ctx context.Context, userID, orig string) (*StoredURL, error) {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
 	}
-	if err := scanner.Err(); err != nil {
+
+	if err := fr.ensureLoaded(); err != nil {
 		return nil, err
 	}
-	return nil, nil
+	if u := fr.findByOriginalURL(userID, orig); u != nil {
+		return u, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (fr *FileRepository) findByOriginalURL(userID, orig string) *StoredURL {
+	key, ok := fr.dedup.key(userID, orig)
+	if !ok {
+		return nil
+	}
+	if id, ok := fr.byOriginal[key]; ok {
+		u := fr.byShortID[id].url
+		return &u
+	}
+	return nil
 }
 
-func (fr *FileRepository) FindByOriginalURL(orig string) (*StoredURL, error) {
+func (fr *FileRepository) BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error) {
 	fr.urlsMutex.Lock()
 	defer fr.urlsMutex.Unlock()
 
-	file, err := os.Open(fr.Path)
-	if err != nil {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if err := fr.ensureLoaded(); err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			if entry.OriginalURL == orig {
-				return &entry, nil
-			}
+	results := make([]BatchResult, len(urls))
+	byShortID := make(map[string]struct{}, len(urls))
+	byOriginal := make(map[string]int, len(urls))
+	var created []StoredURL
+	for i, u := range urls {
+		if existing := fr.findByOriginalURL(u.UserID, u.OriginalURL); existing != nil {
+			results[i] = BatchResult{Status: BatchExists, Existing: existing}
+			continue
+		}
+		key, dedup := fr.dedup.key(u.UserID, u.OriginalURL)
+		if j, ok := byOriginal[key]; ok && dedup {
+			existing := urls[j]
+			results[i] = BatchResult{Status: BatchExists, Existing: &existing}
+			continue
 		}
+		if _, ok := fr.byShortID[u.ShortURL]; ok {
+			results[i] = BatchResult{Status: BatchConflict}
+			continue
+		}
+		if _, ok := byShortID[u.ShortURL]; ok {
+			results[i] = BatchResult{Status: BatchConflict}
+			continue
+		}
+
+		byShortID[u.ShortURL] = struct{}{}
+		if dedup {
+			byOriginal[key] = i
+		}
+		results[i] = BatchResult{Status: BatchCreated}
+		created = append(created, u)
 	}
-	if err := scanner.Err(); err != nil {
+	if len(created) == 0 {
+		return results, nil
+	}
+
+	records := make([]any, 0, len(created))
+	for _, u := range created {
+		records = append(records, u)
+	}
+	if err := fr.appendRecords(records); err != nil {
 		return nil, err
 	}
-	return nil, nil
+	for _, u := range created {
+		fr.index(u)
+	}
+	return results, fr.maybeCompact()
 }
 
-func (fr *FileRepository) BatchSave(ctx context.Context, urls []StoredURL) error {
+func (fr *FileRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
+
+	if err := fr.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	ids := fr.byUser[userID]
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return fr.sortedEntries(ids), nil
+}
+
+func (fr *FileRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
+	return fr.MarkDeletedBatch(ctx, []DeleteRequest{{UserID: userID, ShortIDs: ids}})
+}
+
+func (fr *FileRepository) MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error {
 	fr.urlsMutex.Lock()
 	defer fr.urlsMutex.Unlock()
 
@@ -165,68 +548,118 @@ func (fr *FileRepository) BatchSave(ctx context.Context, urls []StoredURL) error
 	default:
 	}
 
-	file, err := os.OpenFile(fr.Path, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
+	if err := fr.ensureLoaded(); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var existing []StoredURL
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	var records []tombstone
+	queued := make(map[string]struct{})
+	for _, req := range reqs {
+		for _, id := range req.ShortIDs {
+			e, ok := fr.byShortID[id]
+			if !ok || e.url.UserID != req.UserID || e.url.IsDeleted {
+				continue
+			}
+			if _, dup := queued[id]; dup {
+				continue
+			}
+			queued[id] = struct{}{}
+			records = append(records, tombstone{Op: opDelete, ShortURL: id, UserID: req.UserID})
 		}
+	}
+	return fr.appendTombstones(records)
+}
 
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			existing = append(existing, entry)
-		}
+func (fr *FileRepository) appendTombstones(tombstones []tombstone) error {
+	if len(tombstones) == 0 {
+		return nil
 	}
-	if err := scanner.Err(); err != nil {
+	records := make([]any, 0, len(tombstones))
+	for _, t := range tombstones {
+		records = append(records, t)
+	}
+	if err := fr.appendRecords(records); err != nil {
 		return err
 	}
+	for _, t := range tombstones {
+		fr.byShortID[t.ShortURL].url.IsDeleted = true
+	}
+	return fr.maybeCompact()
+}
 
-	existing = append(existing, urls...)
+func (fr *FileRepository) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	fr.urlsMutex.Lock()
+	defer fr.urlsMutex.Unlock()
 
-	tmpPath := fr.Path + ".tmp"
-	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	if err := fr.ensureLoaded(); err != nil {
+		return 0, err
+	}
+
+	var records []tombstone
+	for id, e := range fr.byShortID {
+		if e.url.IsDeleted || !e.url.IsExpired(now) {
+			continue
+		}
+		records = append(records, tombstone{Op: opDelete, ShortURL: id, UserID: e.url.UserID})
+	}
+	if err := fr.appendTombstones(records); err != nil {
+		return 0, err
+	}
+	return int64(len(records)), nil
+}
+
+func (fr *FileRepository) clicksPath() string {
+	return fr.Path + ".clicks"
+}
+
+func (fr *FileRepository) SaveClicks(ctx context.Context, clicks []ClickEvent) error {
+	fr.clicksMutex.Lock()
+	defer fr.clicksMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	file, err := os.OpenFile(fr.clicksPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
-	defer tmpFile.Close()
 
-	enc := json.NewEncoder(tmpFile)
-	for _, u := range existing {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-		if err := enc.Encode(u); err != nil {
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, c := range clicks {
+		if err := enc.Encode(c); err != nil {
+			file.Close()
 			return err
 		}
 	}
-
-	return os.Rename(tmpPath, fr.Path)
-
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func (fr *FileRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
-	fr.urlsMutex.Lock()
-	defer fr.urlsMutex.Unlock()
+func (fr *FileRepository) GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error) {
+	fr.clicksMutex.Lock()
+	defer fr.clicksMutex.Unlock()
 
-	file, err := os.Open(fr.Path)
+	file, err := os.OpenFile(fr.clicksPath(), os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var result []StoredURL
+	var clicks []ClickEvent
 	scanner := bufio.NewScanner(file)
-
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
@@ -234,67 +667,198 @@ func (fr *FileRepository) GetURLsByUserID(ctx context.Context, userID string) ([
 		default:
 		}
 
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			if entry.UserID == userID {
-				result = append(result, entry)
-			}
+		var c ClickEvent
+		if err := json.Unmarshal(scanner.Bytes(), &c); err == nil && c.ShortURL == shortID {
+			clicks = append(clicks, c)
 		}
 	}
-
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	return result, nil
+	return aggregateClicks(clicks), nil
 }
 
-func (fr *FileRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
-	fr.urlsMutex.Lock()
-	defer fr.urlsMutex.Unlock()
+func (fr *FileRepository) idsPath() string {
+	return fr.Path + ".ids"
+}
 
-	file, err := os.OpenFile(fr.Path, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
+// LeaseIDRange keeps the next unleased ID in a sidecar file, replaced
+// atomically, so blocks are not handed out twice across restarts.
+func (fr *FileRepository) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, ErrInvalidRangeSize
 	}
-	defer file.Close()
 
-	var all []StoredURL
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var entry StoredURL
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-			all = append(all, entry)
+	fr.idsMutex.Lock()
+	defer fr.idsMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	var start uint64
+	data, err := os.ReadFile(fr.idsPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return 0, err
+	default:
+		start, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("corrupt id range file %s: %w", fr.idsPath(), err)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+
+	tmpPath := fr.idsPath() + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmpFile.WriteString(strconv.FormatUint(start+size, 10) + "\n"); err != nil {
+		tmpFile.Close()
+		return 0, err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return 0, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, fr.idsPath()); err != nil {
+		return 0, err
 	}
+	return start, nil
+}
+
+func (fr *FileRepository) keysPath() string {
+	return fr.Path + ".keys"
+}
 
-	idSet := make(map[string]struct{}, len(ids))
-	for _, id := range ids {
-		idSet[id] = struct{}{}
+func (fr *FileRepository) loadAPIKeys() error {
+	if fr.keys != nil {
+		return nil
 	}
-	for i, url := range all {
-		if url.UserID == userID {
-			if _, ok := idSet[url.UUID]; ok {
-				all[i].IsDeleted = true
-			}
+	var keys []APIKey
+	data, err := os.ReadFile(fr.keysPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return fmt.Errorf("corrupt api key file %s: %w", fr.keysPath(), err)
 		}
 	}
+	fr.keys = make(map[string]APIKey, len(keys))
+	for _, k := range keys {
+		fr.keys[k.ID] = k
+	}
+	return nil
+}
 
-	tmpPath := fr.Path + ".tmp"
-	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+// updateAPIKeys applies update to a copy of the keys and, if it succeeds,
+// replaces the sidecar file atomically before adopting the copy.
+func (fr *FileRepository) updateAPIKeys(ctx context.Context, update func(keys map[string]APIKey) error) error {
+	fr.keysMutex.Lock()
+	defer fr.keysMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if err := fr.loadAPIKeys(); err != nil {
+		return err
+	}
+	keys := make(map[string]APIKey, len(fr.keys)+1)
+	for id, k := range fr.keys {
+		keys[id] = k
+	}
+	if err := update(keys); err != nil {
+		return err
+	}
+
+	list := make([]APIKey, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, k)
+	}
+	data, err := json.Marshal(list)
 	if err != nil {
 		return err
 	}
-	defer tmpFile.Close()
+	tmpPath := fr.keysPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, fr.keysPath()); err != nil {
+		return err
+	}
+	fr.keys = keys
+	return nil
+}
+
+func (fr *FileRepository) readAPIKeys(ctx context.Context, read func(keys map[string]APIKey)) error {
+	fr.keysMutex.Lock()
+	defer fr.keysMutex.Unlock()
 
-	enc := json.NewEncoder(tmpFile)
-	for _, entry := range all {
-		if err := enc.Encode(entry); err != nil {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if err := fr.loadAPIKeys(); err != nil {
+		return err
+	}
+	read(fr.keys)
+	return nil
+}
+
+func (fr *FileRepository) SaveAPIKey(ctx context.Context, key APIKey) error {
+	return fr.updateAPIKeys(ctx, func(keys map[string]APIKey) error {
+		if err := checkNewAPIKey(keys, key); err != nil {
 			return err
 		}
+		keys[key.ID] = key
+		return nil
+	})
+}
+
+func (fr *FileRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	var (
+		key     *APIKey
+		findErr error
+	)
+	if err := fr.readAPIKeys(ctx, func(keys map[string]APIKey) {
+		key, findErr = findAPIKeyByHash(keys, hash)
+	}); err != nil {
+		return nil, err
+	}
+	return key, findErr
+}
+
+func (fr *FileRepository) GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	var result []APIKey
+	if err := fr.readAPIKeys(ctx, func(keys map[string]APIKey) {
+		result = apiKeysOf(keys, userID)
+	}); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
+
+func (fr *FileRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return fr.updateAPIKeys(ctx, func(keys map[string]APIKey) error {
+		return revokeAPIKey(keys, userID, id, at)
+	})
+}
 
-	return os.Rename(tmpPath, fr.Path)
+func (fr *FileRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return fr.updateAPIKeys(ctx, func(keys map[string]APIKey) error {
+		return touchAPIKey(keys, id, at)
+	})
 }
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileRepositoryJournal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	legacy := `{"uuid":"aaa","short_url":"aaa","original_url":"https://a.example","user_id":"u1","is_deleted":false}
+{"uuid":"bbb","short_url":"bbb","original_url":"https://b.example","user_id":"u1","is_deleted":true}
+`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0666))
+
+	repo := NewFileRepository(path)
+	entry, err := repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.IsDeleted)
+
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "ccc", ShortURL: "ccc", OriginalURL: "https://c.example", UserID: "u2"}))
+	assert.ErrorIs(t, repo.Save(ctx, StoredURL{UUID: "ccc", ShortURL: "ccc", OriginalURL: "https://d.example"}), ErrShortURLConflict)
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"aaa"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), legacy), "existing lines must not be rewritten")
+	assert.Contains(t, string(data), `"op":"delete"`)
+
+	reopened := NewFileRepository(path)
+	entry, err = reopened.FindByOriginalURL(ctx, "u1", "https://a.example")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.IsDeleted)
+
+	urls, err := reopened.GetURLsByUserID(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	require.NoError(t, reopened.Compact())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+	assert.NotContains(t, string(data), `"op"`)
+
+	all, err := NewFileRepository(path).Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, []string{"aaa", "bbb", "ccc"}, []string{all[0].ShortURL, all[1].ShortURL, all[2].ShortURL})
+	assert.True(t, all[0].IsDeleted)
+}
+
+func TestFileRepositoryRecoversDamagedJournal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	long := "https://long.example/" + strings.Repeat("x", 2<<20)
+	journal := `{"uuid":"aaa","short_url":"aaa","original_url":"https://a.example","user_id":"u1"}
+not json
+{"uuid":"big","short_url":"big","original_url":"` + long + `","user_id":"u1"}
+{"uuid":"bbb","short_url":"bb`
+	require.NoError(t, os.WriteFile(path, []byte(journal), 0666))
+
+	repo := NewFileRepository(path)
+	entry, err := repo.FindByShortID(ctx, "big")
+	require.NoError(t, err, "a long line must not fail the repository")
+	assert.Equal(t, long, entry.OriginalURL)
+
+	// The torn record is dropped, so the next one lands on a line of its own.
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "ccc", ShortURL: "ccc", OriginalURL: "https://c.example", UserID: "u1"}))
+	all, err := NewFileRepository(path).Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	// A complete last record without its newline is kept and terminated.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(strings.TrimSuffix(string(data), "\n")), 0666))
+	repo = NewFileRepository(path)
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "ddd", ShortURL: "ddd", OriginalURL: "https://d.example", UserID: "u1"}))
+	all, err = NewFileRepository(path).Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+}
+
+func TestFileRepositoryRunCompactor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	repo := NewFileRepository(path)
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://a.example", UserID: "u1"}))
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"aaa"}))
+
+	go repo.RunCompactor(ctx, time.Millisecond)
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Count(string(data), "\n") == 1
+	}, time.Second, time.Millisecond)
+
+	entry, err := NewFileRepository(path).FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+}
+
+func TestFileRepositoryLeaseIDRange(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	repo := NewFileRepository(path)
+	start, err := repo.LeaseIDRange(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), start)
+	start, err = repo.LeaseIDRange(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), start)
+
+	// A restarted instance continues after the last leased block.
+	start, err = NewFileRepository(path).LeaseIDRange(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), start)
+
+	_, err = repo.LeaseIDRange(ctx, 0)
+	assert.ErrorIs(t, err, ErrInvalidRangeSize)
+}
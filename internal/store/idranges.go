@@ -0,0 +1,15 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrInvalidRangeSize = errors.New("id range size must be positive")
+
+// IDRangeLeaser hands out blocks of sequential IDs. Each call returns the
+// first value of a block [start, start+size) that no other caller, in this
+// process or another, will ever receive.
+type IDRangeLeaser interface {
+	LeaseIDRange(ctx context.Context, size uint64) (start uint64, err error)
+}
@@ -3,21 +3,38 @@ package store
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type InMemoryRepository struct {
-	data map[string]StoredURL
-	mu   *sync.Mutex
+	data   map[string]StoredURL
+	clicks map[string][]ClickEvent
+	keys   map[string]APIKey
+	nextID uint64
+	dedup  DedupScope
+	mu     *sync.Mutex
 }
 
-func NewInMemoryRepository() *InMemoryRepository {
-	return &InMemoryRepository{data: make(map[string]StoredURL), mu: &sync.Mutex{}}
+func NewInMemoryRepository(opts ...Option) *InMemoryRepository {
+	return &InMemoryRepository{
+		data:   make(map[string]StoredURL),
+		clicks: make(map[string][]ClickEvent),
+		keys:   make(map[string]APIKey),
+		dedup:  newOptions(opts).dedup,
+		mu:     &sync.Mutex{},
+	}
 }
 
-func (r *InMemoryRepository) Load() ([]StoredURL, error) {
+func (r *InMemoryRepository) Load(ctx context.Context) ([]StoredURL, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
 	var result []StoredURL
 	for _, entry := range r.data {
 		result = append(result, entry)
@@ -25,58 +42,113 @@ func (r *InMemoryRepository) Load() ([]StoredURL, error) {
 	return result, nil
 }
 
-func (r *InMemoryRepository) Save(entry StoredURL) error {
+func (r *InMemoryRepository) Save(ctx context.Context, entry StoredURL) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := r.data[entry.ShortURL]; ok {
+		return ErrShortURLConflict
+	}
+	if r.findByOriginalURL(entry.UserID, entry.OriginalURL) != nil {
+		return ErrUniqueViolation
+	}
 	r.data[entry.ShortURL] = entry
 	return nil
 }
 
-func (r *InMemoryRepository) FindByShortID(id string) (*StoredURL, error) {
+func (r *InMemoryRepository) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
 	if entry, ok := r.data[id]; ok {
 		return &entry, nil
 	}
-	return nil, nil
+	return nil, ErrNotFound
 }
 
-func (r *InMemoryRepository) FindByOriginalURL(orig string) (*StoredURL, error) {
+func (r *InMemoryRepository) FindByOriginalURL(ctx context.Context, userID, orig string) (*StoredURL, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if entry := r.findByOriginalURL(userID, orig); entry != nil {
+		return entry, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryRepository) findByOriginalURL(userID, orig string) *StoredURL {
+	key, ok := r.dedup.key(userID, orig)
+	if !ok {
+		return nil
+	}
 	for _, entry := range r.data {
-		if entry.OriginalURL == orig {
-			return &entry, nil
+		if k, _ := r.dedup.key(entry.UserID, entry.OriginalURL); k == key {
+			return &entry
 		}
 	}
-	return nil, nil
+	return nil
 }
 
-func (r *InMemoryRepository) Ping() error {
-	return nil
+func (r *InMemoryRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
 }
 
-func (r *InMemoryRepository) BatchSave(ctx context.Context, urls []StoredURL) error {
+func (r *InMemoryRepository) BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	for _, entry := range urls {
+	results := make([]BatchResult, len(urls))
+	for i, entry := range urls {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
+		if existing := r.findByOriginalURL(entry.UserID, entry.OriginalURL); existing != nil {
+			results[i] = BatchResult{Status: BatchExists, Existing: existing}
+			continue
+		}
+		if _, ok := r.data[entry.ShortURL]; ok {
+			results[i] = BatchResult{Status: BatchConflict}
+			continue
+		}
+
 		r.data[entry.ShortURL] = entry
+		results[i] = BatchResult{Status: BatchCreated}
 	}
 
-	return nil
+	return results, nil
 }
 
 func (r *InMemoryRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
 	var result []StoredURL
 	for _, u := range r.data {
 		if u.UserID == userID {
@@ -87,22 +159,117 @@ func (r *InMemoryRepository) GetURLsByUserID(ctx context.Context, userID string)
 }
 
 func (r *InMemoryRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
+	return r.MarkDeletedBatch(ctx, []DeleteRequest{{UserID: userID, ShortIDs: ids}})
+}
+
+func (r *InMemoryRepository) MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	idSet := make(map[string]struct{}, len(ids))
-	for _, id := range ids {
-		idSet[id] = struct{}{}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
 	}
 
-	for key, entry := range r.data {
-		if entry.UserID == userID {
-			if _, ok := idSet[entry.UUID]; ok {
+	for _, req := range reqs {
+		for _, id := range req.ShortIDs {
+			if entry, ok := r.data[id]; ok && entry.UserID == req.UserID {
 				entry.IsDeleted = true
-				r.data[key] = entry
+				r.data[id] = entry
 			}
 		}
 	}
 
 	return nil
 }
+
+func (r *InMemoryRepository) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	var expired int64
+	for key, entry := range r.data {
+		if !entry.IsDeleted && entry.IsExpired(now) {
+			entry.IsDeleted = true
+			r.data[key] = entry
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *InMemoryRepository) SaveClicks(ctx context.Context, clicks []ClickEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range clicks {
+		r.clicks[c.ShortURL] = append(r.clicks[c.ShortURL], c)
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return aggregateClicks(r.clicks[shortID]), nil
+}
+
+func (r *InMemoryRepository) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, ErrInvalidRangeSize
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := r.nextID
+	r.nextID += size
+	return start, nil
+}
+
+func (r *InMemoryRepository) SaveAPIKey(ctx context.Context, key APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := checkNewAPIKey(r.keys, key); err != nil {
+		return err
+	}
+	r.keys[key.ID] = key
+	return nil
+}
+
+func (r *InMemoryRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return findAPIKeyByHash(r.keys, hash)
+}
+
+func (r *InMemoryRepository) GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return apiKeysOf(r.keys, userID), nil
+}
+
+func (r *InMemoryRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return revokeAPIKey(r.keys, userID, id, at)
+}
+
+func (r *InMemoryRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return touchAPIKey(r.keys, id, at)
+}
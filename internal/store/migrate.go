@@ -0,0 +1,292 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies the numbered NNNN_name.up.sql / NNNN_name.down.sql files of
+// one dialect and records applied versions in schema_migrations.
+type Migrator struct {
+	db          *sqlx.DB
+	migrations  []Migration
+	placeholder sq.PlaceholderFormat
+	lock        func(ctx context.Context, tx *sqlx.Tx) error
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %q: expected NNNN_name", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: bad version: %w", name, err)
+		}
+
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// postgresMigrationLock serialises concurrent migrators (e.g. several replicas
+// starting at once) for the lifetime of each migration transaction.
+const postgresMigrationLock = 72616375
+
+func newPostgresMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := loadMigrations("migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:          db,
+		migrations:  migrations,
+		placeholder: sq.Dollar,
+		lock: func(ctx context.Context, tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock)
+			return err
+		},
+	}, nil
+}
+
+// OpenPostgresMigrator connects to dsn for running migrations by hand.
+func OpenPostgresMigrator(ctx context.Context, dsn string) (*Migrator, error) {
+	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to db: %w", err)
+	}
+	m, err := newPostgresMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// SQLite needs no extra lock: migration transactions are opened with
+// BEGIN IMMEDIATE (see sqliteDSN), which already excludes other writers.
+func newSQLiteMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := loadMigrations("migrations/sqlite")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, placeholder: sq.Question}, nil
+}
+
+// OpenSQLiteMigrator opens the database file at path for running migrations
+// by hand.
+func OpenSQLiteMigrator(ctx context.Context, path string) (*Migrator, error) {
+	db, err := sqlx.ConnectContext(ctx, "sqlite3", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+	m, err := newSQLiteMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`)
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context, q sqlx.QueryerContext) (map[int]bool, error) {
+	query, args, err := sq.Select("version").From("schema_migrations").PlaceholderFormat(m.placeholder).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	if err := sqlx.SelectContext(ctx, q, &versions, query, args...); err != nil {
+		return nil, err
+	}
+	result := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		result[v] = true
+	}
+	return result, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		result = append(result, MigrationStatus{Migration: mig, Applied: applied[mig.Version]})
+	}
+	return result, nil
+}
+
+// Up applies every pending migration in order and returns how many ran.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		ran, err := m.step(ctx, mig, true)
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		if ran {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Down reverts up to steps of the most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		mig := m.migrations[i]
+		if mig.Down == "" {
+			return count, fmt.Errorf("migration %04d_%s has no down script", mig.Version, mig.Name)
+		}
+		ran, err := m.step(ctx, mig, false)
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		if ran {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Migrator) step(ctx context.Context, mig Migration, up bool) (bool, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if m.lock != nil {
+		if err := m.lock(ctx, tx); err != nil {
+			return false, err
+		}
+	}
+
+	applied, err := m.applied(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if applied[mig.Version] == up {
+		return false, nil
+	}
+
+	script := mig.Up
+	var record sq.Sqlizer = sq.Insert("schema_migrations").
+		Columns("version", "name").
+		Values(mig.Version, mig.Name).
+		PlaceholderFormat(m.placeholder)
+	if !up {
+		script = mig.Down
+		record = sq.Delete("schema_migrations").
+			Where(sq.Eq{"version": mig.Version}).
+			PlaceholderFormat(m.placeholder)
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return false, err
+	}
+
+	query, args, err := record.ToSql()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresMigrationsAreComplete(t *testing.T) {
+	migrations, err := loadMigrations("migrations/postgres")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "versions must be contiguous")
+		assert.NotEmpty(t, m.Up, "%04d_%s up", m.Version, m.Name)
+		assert.NotEmpty(t, m.Down, "%04d_%s down", m.Version, m.Name)
+	}
+}
+
+func TestSQLiteMigrationsMirrorPostgres(t *testing.T) {
+	pg, err := loadMigrations("migrations/postgres")
+	require.NoError(t, err)
+	lite, err := loadMigrations("migrations/sqlite")
+	require.NoError(t, err)
+
+	require.Len(t, lite, len(pg))
+	for i := range pg {
+		assert.Equal(t, pg[i].Version, lite[i].Version)
+		assert.Equal(t, pg[i].Name, lite[i].Name)
+		assert.NotEmpty(t, lite[i].Down, "%04d_%s down", lite[i].Version, lite[i].Name)
+	}
+}
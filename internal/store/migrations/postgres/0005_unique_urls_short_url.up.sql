@@ -0,0 +1,2 @@
+CREATE UNIQUE INDEX IF NOT EXISTS urls_short_url_key ON urls (short_url);
+DROP INDEX IF EXISTS urls_short_url_idx;
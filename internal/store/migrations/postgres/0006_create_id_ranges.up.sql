@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS id_ranges (
+    name TEXT PRIMARY KEY,
+    next_id BIGINT NOT NULL
+);
+INSERT INTO id_ranges (name, next_id) VALUES ('urls', 0) ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS urls_user_original_url_key;
+DROP INDEX IF EXISTS urls_original_url_key;
+DROP INDEX IF EXISTS urls_original_url_idx;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
@@ -0,0 +1,2 @@
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+CREATE INDEX IF NOT EXISTS urls_original_url_idx ON urls (original_url);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS urls (
+    uuid TEXT PRIMARY KEY,
+    short_url TEXT NOT NULL,
+    original_url TEXT NOT NULL UNIQUE,
+    user_id TEXT,
+    is_deleted BOOLEAN NOT NULL DEFAULT false
+);
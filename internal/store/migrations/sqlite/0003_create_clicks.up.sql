@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS clicks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_url TEXT NOT NULL,
+    clicked_at TIMESTAMP NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_bucket TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url, clicked_at);
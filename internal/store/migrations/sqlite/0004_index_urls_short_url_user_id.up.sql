@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS urls_short_url_idx ON urls (short_url);
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
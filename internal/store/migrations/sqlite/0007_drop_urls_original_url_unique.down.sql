@@ -0,0 +1,14 @@
+CREATE TABLE urls_old (
+    uuid TEXT PRIMARY KEY,
+    short_url TEXT NOT NULL,
+    original_url TEXT NOT NULL UNIQUE,
+    user_id TEXT,
+    is_deleted BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP
+);
+INSERT INTO urls_old (uuid, short_url, original_url, user_id, is_deleted, expires_at)
+    SELECT uuid, short_url, original_url, user_id, is_deleted, expires_at FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_old RENAME TO urls;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_short_url_key ON urls (short_url);
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
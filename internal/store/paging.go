@@ -0,0 +1,17 @@
+package store
+
+import "context"
+
+// URLPager lists every stored link, deleted ones included, a page at a time
+// in short ID order, so a full scan needn't hold the table in memory.
+type URLPager interface {
+	// ListURLsAfter returns up to limit links whose short ID sorts after
+	// after; "" starts from the beginning.
+	ListURLsAfter(ctx context.Context, after string, limit int) ([]StoredURL, error)
+}
+
+// URLCounter counts stored links, deleted ones included, without loading
+// them.
+type URLCounter interface {
+	CountURLs(ctx context.Context) (int64, error)
+}
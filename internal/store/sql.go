@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
@@ -13,75 +16,107 @@ import (
 )
 
 type SQLRepository struct {
-	db *sqlx.DB
+	db    *sqlx.DB
+	dedup DedupScope
 }
 
-func NewPostgresRepository(dsn string) (Repository, error) {
-	db, err := sqlx.Connect("pgx", dsn)
+// uuid is the primary key and always equals short_url, so a violation of
+// either urls_pkey or the unique short_url index means the short ID is taken.
+const (
+	urlsPrimaryKey  = "urls_pkey"
+	urlsShortURLKey = "urls_short_url_key"
+)
+
+func mapPgError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		if pgErr.ConstraintName == urlsPrimaryKey || pgErr.ConstraintName == urlsShortURLKey {
+			return ErrShortURLConflict
+		}
+		return ErrUniqueViolation
+	}
+	return err
+}
+
+func NewPostgresRepository(ctx context.Context, dsn string, opts ...Option) (Repository, error) {
+	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to db: %w", err)
 	}
 
-	schema := `
-CREATE TABLE IF NOT EXISTS urls (
-    uuid TEXT PRIMARY KEY,
-    short_url TEXT NOT NULL,
-    original_url TEXT NOT NULL UNIQUE,
-	user_id TEXT,
-	is_deleted BOOLEAN NOT NULL DEFAULT false
-);`
-	_, err = db.Exec(schema)
+	migrator, err := newPostgresMigrator(db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+		db.Close()
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	dedup := newOptions(opts).dedup
+	if err := applyDedupScope(ctx, db, dedup); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return &SQLRepository{db: db}, nil
+	return &SQLRepository{db: db, dedup: dedup}, nil
 }
 
-func (r *SQLRepository) Ping() error {
-	return r.db.Ping()
+func (r *SQLRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
 }
 
 func (r *SQLRepository) Close() error {
 	return r.db.Close()
 }
 
-func (r *SQLRepository) Load() ([]StoredURL, error) {
-	queryBuilder := sq.Select("uuid", "short_url", "original_url").
+func (r *SQLRepository) Load(ctx context.Context) ([]StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
 		From("urls").
-		PlaceholderFormat(sq.Dollar)
-
-	query, args, err := queryBuilder.ToSql()
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := r.db.Query(query, args...)
-	if err != nil {
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return result, nil
+}
 
-	var result []StoredURL
-	for rows.Next() {
-		var url StoredURL
-		if err := rows.Scan(&url.UUID, &url.ShortURL, &url.OriginalURL); err != nil {
-			return nil, err
-		}
-		result = append(result, url)
+func (r *SQLRepository) ListURLsAfter(ctx context.Context, after string, limit int) ([]StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
+		From("urls").
+		Where(sq.Gt{"short_url": after}).
+		OrderBy("short_url").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := rows.Err(); err != nil {
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
 		return nil, err
 	}
-
 	return result, nil
 }
 
-func (r *SQLRepository) Save(entry StoredURL) error {
+func (r *SQLRepository) CountURLs(ctx context.Context) (int64, error) {
+	var n int64
+	err := r.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM urls")
+	return n, err
+}
+
+func (r *SQLRepository) Save(ctx context.Context, entry StoredURL) error {
 	queryBuilder := sq.Insert("urls").
-		Columns("uuid", "short_url", "original_url", "user_id").
-		Values(entry.UUID, entry.ShortURL, entry.OriginalURL, entry.UserID).
+		Columns("uuid", "short_url", "original_url", "user_id", "is_deleted", "expires_at").
+		Values(entry.UUID, entry.ShortURL, entry.OriginalURL, entry.UserID, entry.IsDeleted, entry.ExpiresAt).
 		PlaceholderFormat(sq.Dollar)
 
 	query, args, err := queryBuilder.ToSql()
@@ -89,129 +124,177 @@ func (r *SQLRepository) Save(entry StoredURL) error {
 		return err
 	}
 
-	_, err = r.db.Exec(query, args...)
-	if err != nil {
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrUniqueViolation
-		}
-		return err
-	}
-	return nil
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return mapPgError(err)
 }
 
-func (r *SQLRepository) FindByShortID(id string) (*StoredURL, error) {
-	queryBuilder := sq.
-		Select("uuid", "short_url", "original_url", "is_deleted").
+func (r *SQLRepository) findOne(ctx context.Context, q sqlx.QueryerContext, where sq.Sqlizer) (*StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
 		From("urls").
-		Where(sq.Eq{"short_url": id}).
+		Where(where).
 		Limit(1).
-		PlaceholderFormat(sq.Dollar)
-
-	query, args, err := queryBuilder.ToSql()
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
 	if err != nil {
 		return nil, err
 	}
 
 	var result StoredURL
-	err = r.db.Get(&result, query, args...)
+	err = sqlx.GetContext(ctx, q, &result, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (r *SQLRepository) FindByOriginalURL(original string) (*StoredURL, error) {
-	queryBuilder := sq.
-		Select("uuid", "short_url", "original_url").
-		From("urls").
-		Where(sq.Eq{"original_url": original}).
-		Limit(1).
-		PlaceholderFormat(sq.Dollar)
+func (r *SQLRepository) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
+	return r.findOne(ctx, r.db, sq.Eq{"short_url": id})
+}
 
-	query, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return nil, err
-	}
+func (r *SQLRepository) FindByOriginalURL(ctx context.Context, userID, original string) (*StoredURL, error) {
+	return r.findByOriginalURL(ctx, r.db, userID, original)
+}
 
-	var result StoredURL
-	err = r.db.Get(&result, query, args...)
-	if err != nil {
-		return nil, err
+func (r *SQLRepository) findByOriginalURL(ctx context.Context, q sqlx.QueryerContext, userID, original string) (*StoredURL, error) {
+	where, ok := r.dedup.where(userID, original)
+	if !ok {
+		return nil, ErrNotFound
 	}
-	return &result, nil
+	return r.findOne(ctx, q, where)
 }
 
-func (r *SQLRepository) BatchSave(ctx context.Context, urls []StoredURL) error {
-
+func (r *SQLRepository) BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error) {
 	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	stmtStr := "INSERT INTO urls (uuid, short_url, original_url, user_id) VALUES ($1, $2, $3, $4)"
+	stmtStr := "INSERT INTO urls (uuid, short_url, original_url, user_id, is_deleted, expires_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
 	stmt, err := tx.PrepareContext(ctx, stmtStr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stmt.Close()
 
-	for _, u := range urls {
-		if _, err := stmt.ExecContext(ctx, u.UUID, u.ShortURL, u.OriginalURL, u.UserID); err != nil {
-			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == pgerrcode.UniqueViolation {
-				return ErrUniqueViolation
-			}
-			return err
+	results := make([]BatchResult, len(urls))
+	for i, u := range urls {
+		res, err := stmt.ExecContext(ctx, u.UUID, u.ShortURL, u.OriginalURL, u.UserID, u.IsDeleted, u.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			results[i] = BatchResult{Status: BatchCreated}
+			continue
+		}
+
+		// Nothing inserted: either the original URL exists within the dedup
+		// scope or the short ID is taken.
+		existing, err := r.findByOriginalURL(ctx, tx, u.UserID, u.OriginalURL)
+		switch {
+		case err == nil:
+			results[i] = BatchResult{Status: BatchExists, Existing: existing}
+		case errors.Is(err, ErrNotFound):
+			results[i] = BatchResult{Status: BatchConflict}
+		default:
+			return nil, err
 		}
 	}
 
-	return tx.Commit()
+	return results, tx.Commit()
 }
 
 func (r *SQLRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
-	queryBuilder := sq.
-		Select("short_url", "original_url").
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
 		From("urls").
 		Where(sq.Eq{"user_id": userID}).
-		PlaceholderFormat(sq.Dollar)
-
-	query, args, err := queryBuilder.ToSql()
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := r.db.QueryxContext(ctx, query, args...)
-	if err != nil {
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return result, nil
+}
 
-	var res []StoredURL
-	for rows.Next() {
-		var u StoredURL
-		if err := rows.Scan(&u.ShortURL, &u.OriginalURL); err != nil {
-			return nil, err
+func (r *SQLRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
+	return r.MarkDeletedBatch(ctx, []DeleteRequest{{UserID: userID, ShortIDs: ids}})
+}
+
+func (r *SQLRepository) MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error {
+	cond := sq.Or{}
+	for _, req := range reqs {
+		if len(req.ShortIDs) == 0 {
+			continue
 		}
-		res = append(res, u)
+		cond = append(cond, sq.And{
+			sq.Eq{"user_id": req.UserID},
+			sq.Eq{"short_url": req.ShortIDs},
+		})
+	}
+	if len(cond) == 0 {
+		return nil
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	queryBuilder := sq.
+		Update("urls").
+		Set("is_deleted", true).
+		Where(cond).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return err
 	}
-	return res, nil
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+
+	return err
 }
 
-func (r *SQLRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
-	if len(ids) == 0 {
+func (r *SQLRepository) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	queryBuilder := sq.
+		Update("urls").
+		Set("is_deleted", true).
+		Where(sq.LtOrEq{"expires_at": now}).
+		Where(sq.Eq{"is_deleted": false}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *SQLRepository) SaveClicks(ctx context.Context, clicks []ClickEvent) error {
+	if len(clicks) == 0 {
 		return nil
 	}
 
 	queryBuilder := sq.
-		Update("urls").
-		Set("is_deleted", true).
-		Where(sq.Eq{"user_id": userID}).
-		Where(sq.Eq{"short_url": ids}).
+		Insert("clicks").
+		Columns("short_url", "clicked_at", "referrer", "user_agent", "ip_bucket").
 		PlaceholderFormat(sq.Dollar)
+	for _, c := range clicks {
+		queryBuilder = queryBuilder.Values(c.ShortURL, c.ClickedAt, c.Referrer, c.UserAgent, c.IPBucket)
+	}
 
 	query, args, err := queryBuilder.ToSql()
 	if err != nil {
@@ -219,6 +302,157 @@ func (r *SQLRepository) MarkDeleted(ctx context.Context, userID string, ids []st
 	}
 
 	_, err = r.db.ExecContext(ctx, query, args...)
-
 	return err
 }
+
+func (r *SQLRepository) GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error) {
+	totalsQuery, args, err := sq.
+		Select("COUNT(*)", "COUNT(DISTINCT ip_bucket || '|' || user_agent)").
+		From("clicks").
+		Where(sq.Eq{"short_url": shortID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LinkStats{Daily: []DailyClicks{}}
+	if err := r.db.QueryRowxContext(ctx, totalsQuery, args...).Scan(&stats.TotalClicks, &stats.UniqueVisitors); err != nil {
+		return nil, err
+	}
+
+	dailyQuery, args, err := sq.
+		Select("to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day", "COUNT(*) AS clicks").
+		From("clicks").
+		Where(sq.Eq{"short_url": shortID}).
+		GroupBy("day").
+		OrderBy("day").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.db.SelectContext(ctx, &stats.Daily, dailyQuery, args...); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// LeaseIDRange advances the shared counter in id_ranges in a single
+// statement, so concurrent replicas never receive overlapping blocks.
+func (r *SQLRepository) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, ErrInvalidRangeSize
+	}
+
+	var end int64
+	err := r.db.QueryRowxContext(ctx,
+		"UPDATE id_ranges SET next_id = next_id + $1 WHERE name = 'urls' RETURNING next_id",
+		int64(size),
+	).Scan(&end)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(end) - size, nil
+}
+
+var apiKeyColumns = []string{"id", "user_id", "name", "key_hash", "created_at", "expires_at", "last_used_at", "revoked_at"}
+
+func (r *SQLRepository) SaveAPIKey(ctx context.Context, key APIKey) error {
+	query, args, err := sq.
+		Insert("api_keys").
+		Columns(apiKeyColumns...).
+		Values(key.ID, key.UserID, key.Name, key.Hash, key.CreatedAt, key.ExpiresAt, key.LastUsedAt, key.RevokedAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return mapPgError(err)
+}
+
+func (r *SQLRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	query, args, err := sq.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"key_hash": hash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	err = r.db.GetContext(ctx, &key, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *SQLRepository) GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	query, args, err := sq.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created_at", "id").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	if err := r.db.SelectContext(ctx, &keys, query, args...); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *SQLRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	query, args, err := sq.
+		Update("api_keys").
+		Set("revoked_at", sq.Expr("COALESCE(revoked_at, ?)", at)).
+		Where(sq.Eq{"id": id, "user_id": userID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	return r.execOne(ctx, query, args...)
+}
+
+func (r *SQLRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	query, args, err := sq.
+		Update("api_keys").
+		Set("last_used_at", at).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	return r.execOne(ctx, query, args...)
+}
+
+// execOne runs an update that must match a row, returning ErrNotFound if it
+// didn't.
+func (r *SQLRepository) execOne(ctx context.Context, query string, args ...any) error {
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
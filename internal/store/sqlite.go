@@ -0,0 +1,472 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteRepository stores links in a single SQLite database file, with the
+// same schema and constraints as the Postgres backend.
+type SQLiteRepository struct {
+	db    *sqlx.DB
+	dedup DedupScope
+}
+
+// sqliteDSN turns a file path into a go-sqlite3 DSN. Writers wait for each
+// other instead of failing with SQLITE_BUSY, and transactions take the write
+// lock up front so two of them can't deadlock upgrading a read lock.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", "5000")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_foreign_keys", "on")
+	params.Set("_txlock", "immediate")
+	return "file:" + path + "?" + params.Encode()
+}
+
+// mapSQLiteError maps unique violations to the same errors mapPgError uses.
+// SQLite names the offending column rather than the constraint.
+func mapSQLiteError(err error) error {
+	var liteErr sqlite3.Error
+	if !errors.As(err, &liteErr) {
+		return err
+	}
+	if liteErr.ExtendedCode != sqlite3.ErrConstraintUnique && liteErr.ExtendedCode != sqlite3.ErrConstraintPrimaryKey {
+		return err
+	}
+	msg := liteErr.Error()
+	if strings.Contains(msg, "urls.uuid") || strings.Contains(msg, "urls.short_url") {
+		return ErrShortURLConflict
+	}
+	return ErrUniqueViolation
+}
+
+func NewSQLiteRepository(ctx context.Context, path string, opts ...Option) (*SQLiteRepository, error) {
+	db, err := sqlx.ConnectContext(ctx, "sqlite3", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+
+	migrator, err := newSQLiteMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	dedup := newOptions(opts).dedup
+	if err := applyDedupScope(ctx, db, dedup); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteRepository{db: db, dedup: dedup}, nil
+}
+
+func (r *SQLiteRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
+// utc keeps stored timestamps in one zone, so SQLite's text comparison of
+// TIMESTAMP columns orders them correctly.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func (r *SQLiteRepository) Load(ctx context.Context) ([]StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
+		From("urls").
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *SQLiteRepository) ListURLsAfter(ctx context.Context, after string, limit int) ([]StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
+		From("urls").
+		Where(sq.Gt{"short_url": after}).
+		OrderBy("short_url").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *SQLiteRepository) CountURLs(ctx context.Context) (int64, error) {
+	var n int64
+	err := r.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM urls")
+	return n, err
+}
+
+func (r *SQLiteRepository) Save(ctx context.Context, entry StoredURL) error {
+	query, args, err := sq.Insert("urls").
+		Columns("uuid", "short_url", "original_url", "user_id", "is_deleted", "expires_at").
+		Values(entry.UUID, entry.ShortURL, entry.OriginalURL, entry.UserID, entry.IsDeleted, utc(entry.ExpiresAt)).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) findOne(ctx context.Context, q sqlx.QueryerContext, where sq.Sqlizer) (*StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
+		From("urls").
+		Where(where).
+		Limit(1).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var result StoredURL
+	err = sqlx.GetContext(ctx, q, &result, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *SQLiteRepository) FindByShortID(ctx context.Context, id string) (*StoredURL, error) {
+	return r.findOne(ctx, r.db, sq.Eq{"short_url": id})
+}
+
+func (r *SQLiteRepository) FindByOriginalURL(ctx context.Context, userID, original string) (*StoredURL, error) {
+	return r.findByOriginalURL(ctx, r.db, userID, original)
+}
+
+func (r *SQLiteRepository) findByOriginalURL(ctx context.Context, q sqlx.QueryerContext, userID, original string) (*StoredURL, error) {
+	where, ok := r.dedup.where(userID, original)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.findOne(ctx, q, where)
+}
+
+func (r *SQLiteRepository) BatchSave(ctx context.Context, urls []StoredURL) ([]BatchResult, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO urls (uuid, short_url, original_url, user_id, is_deleted, expires_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	results := make([]BatchResult, len(urls))
+	for i, u := range urls {
+		res, err := stmt.ExecContext(ctx, u.UUID, u.ShortURL, u.OriginalURL, u.UserID, u.IsDeleted, utc(u.ExpiresAt))
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			results[i] = BatchResult{Status: BatchCreated}
+			continue
+		}
+
+		// Nothing inserted: either the original URL exists within the dedup
+		// scope or the short ID is taken.
+		existing, err := r.findByOriginalURL(ctx, tx, u.UserID, u.OriginalURL)
+		switch {
+		case err == nil:
+			results[i] = BatchResult{Status: BatchExists, Existing: existing}
+		case errors.Is(err, ErrNotFound):
+			results[i] = BatchResult{Status: BatchConflict}
+		default:
+			return nil, err
+		}
+	}
+
+	return results, tx.Commit()
+}
+
+func (r *SQLiteRepository) GetURLsByUserID(ctx context.Context, userID string) ([]StoredURL, error) {
+	query, args, err := sq.
+		Select("uuid", "short_url", "original_url", "COALESCE(user_id, '') AS user_id", "is_deleted", "expires_at").
+		From("urls").
+		Where(sq.Eq{"user_id": userID}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StoredURL
+	if err := r.db.SelectContext(ctx, &result, query, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *SQLiteRepository) MarkDeleted(ctx context.Context, userID string, ids []string) error {
+	return r.MarkDeletedBatch(ctx, []DeleteRequest{{UserID: userID, ShortIDs: ids}})
+}
+
+func (r *SQLiteRepository) MarkDeletedBatch(ctx context.Context, reqs []DeleteRequest) error {
+	cond := sq.Or{}
+	for _, req := range reqs {
+		if len(req.ShortIDs) == 0 {
+			continue
+		}
+		cond = append(cond, sq.And{
+			sq.Eq{"user_id": req.UserID},
+			sq.Eq{"short_url": req.ShortIDs},
+		})
+	}
+	if len(cond) == 0 {
+		return nil
+	}
+
+	query, args, err := sq.
+		Update("urls").
+		Set("is_deleted", true).
+		Where(cond).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *SQLiteRepository) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	query, args, err := sq.
+		Update("urls").
+		Set("is_deleted", true).
+		Where(sq.LtOrEq{"expires_at": now.UTC()}).
+		Where(sq.Eq{"is_deleted": false}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *SQLiteRepository) SaveClicks(ctx context.Context, clicks []ClickEvent) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	queryBuilder := sq.
+		Insert("clicks").
+		Columns("short_url", "clicked_at", "referrer", "user_agent", "ip_bucket").
+		PlaceholderFormat(sq.Question)
+	for _, c := range clicks {
+		queryBuilder = queryBuilder.Values(c.ShortURL, c.ClickedAt.UTC(), c.Referrer, c.UserAgent, c.IPBucket)
+	}
+
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *SQLiteRepository) GetLinkStats(ctx context.Context, shortID string) (*LinkStats, error) {
+	totalsQuery, args, err := sq.
+		Select("COUNT(*)", "COUNT(DISTINCT ip_bucket || '|' || user_agent)").
+		From("clicks").
+		Where(sq.Eq{"short_url": shortID}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LinkStats{Daily: []DailyClicks{}}
+	if err := r.db.QueryRowxContext(ctx, totalsQuery, args...).Scan(&stats.TotalClicks, &stats.UniqueVisitors); err != nil {
+		return nil, err
+	}
+
+	// clicked_at is stored in UTC, so its date prefix is the UTC day.
+	dailyQuery, args, err := sq.
+		Select("substr(clicked_at, 1, 10) AS day", "COUNT(*) AS clicks").
+		From("clicks").
+		Where(sq.Eq{"short_url": shortID}).
+		GroupBy("day").
+		OrderBy("day").
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.db.SelectContext(ctx, &stats.Daily, dailyQuery, args...); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (r *SQLiteRepository) LeaseIDRange(ctx context.Context, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, ErrInvalidRangeSize
+	}
+
+	var end int64
+	err := r.db.QueryRowxContext(ctx,
+		"UPDATE id_ranges SET next_id = next_id + ? WHERE name = 'urls' RETURNING next_id",
+		int64(size),
+	).Scan(&end)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(end) - size, nil
+}
+
+func (r *SQLiteRepository) SaveAPIKey(ctx context.Context, key APIKey) error {
+	query, args, err := sq.
+		Insert("api_keys").
+		Columns(apiKeyColumns...).
+		Values(key.ID, key.UserID, key.Name, key.Hash, key.CreatedAt.UTC(), utc(key.ExpiresAt), utc(key.LastUsedAt), utc(key.RevokedAt)).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	query, args, err := sq.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"key_hash": hash}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	err = r.db.GetContext(ctx, &key, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *SQLiteRepository) GetAPIKeysByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	query, args, err := sq.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created_at", "id").
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	if err := r.db.SelectContext(ctx, &keys, query, args...); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *SQLiteRepository) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	query, args, err := sq.
+		Update("api_keys").
+		Set("revoked_at", sq.Expr("COALESCE(revoked_at, ?)", at.UTC())).
+		Where(sq.Eq{"id": id, "user_id": userID}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	return r.execOne(ctx, query, args...)
+}
+
+func (r *SQLiteRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	query, args, err := sq.
+		Update("api_keys").
+		Set("last_used_at", at.UTC()).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Question).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	return r.execOne(ctx, query, args...)
+}
+
+// execOne runs an update that must match a row, returning ErrNotFound if it
+// didn't.
+func (r *SQLiteRepository) execOne(ctx context.Context, query string, args ...any) error {
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
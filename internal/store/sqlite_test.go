@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.db")
+
+	repo, err := NewSQLiteRepository(ctx, path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://a.example", UserID: "u1"}))
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "bbb", ShortURL: "bbb", OriginalURL: "https://b.example", UserID: "u1", ExpiresAt: &past}))
+	assert.ErrorIs(t, repo.Save(ctx, StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://c.example"}), ErrShortURLConflict)
+	assert.ErrorIs(t, repo.Save(ctx, StoredURL{UUID: "ccc", ShortURL: "ccc", OriginalURL: "https://a.example"}), ErrUniqueViolation)
+
+	entry, err := repo.FindByOriginalURL(ctx, "u1", "https://a.example")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, "aaa", entry.ShortURL)
+	_, err = repo.FindByShortID(ctx, "zzz")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	results, err := repo.BatchSave(ctx, []StoredURL{
+		{UUID: "ddd", ShortURL: "ddd", OriginalURL: "https://d.example", UserID: "u2"},
+		{UUID: "eee", ShortURL: "eee", OriginalURL: "https://a.example", UserID: "u2"},
+		{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://f.example", UserID: "u2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BatchCreated, results[0].Status)
+	assert.Equal(t, BatchExists, results[1].Status)
+	assert.Equal(t, "aaa", results[1].Existing.ShortURL)
+	assert.Equal(t, BatchConflict, results[2].Status)
+
+	expired, err := repo.ExpireURLs(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), expired)
+
+	require.NoError(t, repo.MarkDeleted(ctx, "u2", []string{"aaa", "ddd"}))
+	urls, err := repo.GetURLsByUserID(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	for _, u := range urls {
+		assert.Equal(t, u.ShortURL == "bbb", u.IsDeleted, u.ShortURL)
+	}
+	entry, err = repo.FindByShortID(ctx, "ddd")
+	require.NoError(t, err)
+	assert.True(t, entry.IsDeleted)
+
+	day := time.Date(2025, 6, 1, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	require.NoError(t, repo.SaveClicks(ctx, []ClickEvent{
+		{ShortURL: "aaa", ClickedAt: day, IPBucket: "10.0.0.0/24", UserAgent: "a"},
+		{ShortURL: "aaa", ClickedAt: day, IPBucket: "10.0.0.0/24", UserAgent: "b"},
+	}))
+	stats, err := repo.GetLinkStats(ctx, "aaa")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalClicks)
+	assert.Equal(t, int64(2), stats.UniqueVisitors)
+	assert.Equal(t, []DailyClicks{{Date: "2025-06-02", Clicks: 2}}, stats.Daily)
+
+	first, err := repo.LeaseIDRange(ctx, 10)
+	require.NoError(t, err)
+	second, err := repo.LeaseIDRange(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, first+10, second)
+
+	// Reopening applies no migrations twice and keeps the data.
+	require.NoError(t, repo.Close())
+	repo, err = NewSQLiteRepository(ctx, path)
+	require.NoError(t, err)
+	all, err := repo.Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestSQLiteMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	m, err := OpenSQLiteMigrator(ctx, filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	defer m.Close()
+
+	n, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(m.migrations), n)
+
+	n, err = m.Down(ctx, len(m.migrations))
+	require.NoError(t, err)
+	assert.Equal(t, len(m.migrations), n)
+}
+
+func TestSQLiteDedupScopeSwitch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.db")
+
+	repo, err := NewSQLiteRepository(ctx, path, WithDedupScope(DedupPerUser))
+	require.NoError(t, err)
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "aaa", ShortURL: "aaa", OriginalURL: "https://a.example", UserID: "u1"}))
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "bbb", ShortURL: "bbb", OriginalURL: "https://a.example", UserID: "u2"}))
+	require.NoError(t, repo.Close())
+
+	// The per-user links already break global uniqueness.
+	_, err = NewSQLiteRepository(ctx, path, WithDedupScope(DedupGlobal))
+	assert.ErrorContains(t, err, "dedup scope")
+
+	repo, err = NewSQLiteRepository(ctx, path, WithDedupScope(DedupNone))
+	require.NoError(t, err)
+	defer repo.Close()
+	require.NoError(t, repo.Save(ctx, StoredURL{UUID: "ccc", ShortURL: "ccc", OriginalURL: "https://a.example", UserID: "u1"}))
+}
@@ -0,0 +1,454 @@
+// Package storetest is the behaviour every store.Repository must share. Each
+// backend's tests call Run with a constructor for an empty repository.
+package storetest
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the suite. newRepo must return an empty repository configured with
+// opts; it is called once per subtest.
+func Run(t *testing.T, newRepo func(t *testing.T, opts ...store.Option) store.Repository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo store.Repository)
+	}{
+		{"SaveAndFind", testSaveAndFind},
+		{"SaveKeepsAllFields", testSaveKeepsAllFields},
+		{"NotFound", testNotFound},
+		{"SaveConflicts", testSaveConflicts},
+		{"BatchSave", testBatchSave},
+		{"GetURLsByUserID", testGetURLsByUserID},
+		{"MarkDeleted", testMarkDeleted},
+		{"MarkDeletedBatch", testMarkDeletedBatch},
+		{"ExpireURLs", testExpireURLs},
+		{"Load", testLoad},
+		{"Ping", testPing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+
+	t.Run("DedupPerUser", func(t *testing.T) {
+		testDedupPerUser(t, newRepo(t, store.WithDedupScope(store.DedupPerUser)))
+	})
+	t.Run("DedupNone", func(t *testing.T) {
+		testDedupNone(t, newRepo(t, store.WithDedupScope(store.DedupNone)))
+	})
+	t.Run("APIKeys", func(t *testing.T) {
+		ks, ok := store.AsAPIKeyStore(newRepo(t))
+		if !ok {
+			t.Skip("repository does not store API keys")
+		}
+		testAPIKeys(t, ks)
+	})
+	t.Run("ListURLsAfter", func(t *testing.T) {
+		repo := newRepo(t)
+		pager, ok := store.AsURLPager(repo)
+		if !ok {
+			t.Skip("repository does not page through links")
+		}
+		testListURLsAfter(t, repo, pager)
+	})
+	t.Run("CountURLs", func(t *testing.T) {
+		repo := newRepo(t)
+		counter, ok := store.AsURLCounter(repo)
+		if !ok {
+			t.Skip("repository does not count links")
+		}
+		ctx := context.Background()
+		n, err := counter.CountURLs(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+		require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+		require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/b", "u1")))
+		require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"bbb"}))
+		n, err = counter.CountURLs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n, "deleted links still hold their IDs")
+	})
+}
+
+func link(id, original, userID string) store.StoredURL {
+	return store.StoredURL{UUID: id, ShortURL: id, OriginalURL: original, UserID: userID}
+}
+
+// sameURL compares entries field by field; timestamps only need to denote
+// the same instant, since backends may change zone or precision.
+func sameURL(t *testing.T, want, got store.StoredURL) {
+	t.Helper()
+	assert.Equal(t, want.UUID, got.UUID, "uuid")
+	assert.Equal(t, want.ShortURL, got.ShortURL, "short_url")
+	assert.Equal(t, want.OriginalURL, got.OriginalURL, "original_url")
+	assert.Equal(t, want.UserID, got.UserID, "user_id")
+	assert.Equal(t, want.IsDeleted, got.IsDeleted, "is_deleted")
+	if want.ExpiresAt == nil {
+		assert.Nil(t, got.ExpiresAt, "expires_at")
+	} else if assert.NotNil(t, got.ExpiresAt, "expires_at") {
+		assert.True(t, want.ExpiresAt.Equal(*got.ExpiresAt), "expires_at: want %v, got %v", want.ExpiresAt, got.ExpiresAt)
+	}
+}
+
+func byShortURL(urls []store.StoredURL) []store.StoredURL {
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ShortURL < urls[j].ShortURL })
+	return urls
+}
+
+func testSaveAndFind(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	want := link("abc123", "https://example.com/a", "u1")
+	want.ExpiresAt = &expires
+	require.NoError(t, repo.Save(ctx, want))
+
+	got, err := repo.FindByShortID(ctx, "abc123")
+	require.NoError(t, err)
+	sameURL(t, want, *got)
+
+	got, err = repo.FindByOriginalURL(ctx, "u1", "https://example.com/a")
+	require.NoError(t, err)
+	sameURL(t, want, *got)
+}
+
+// Imports copy links between backends, so tombstones must survive Save and
+// BatchSave.
+func testSaveKeepsAllFields(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	deleted := link("aaa", "https://example.com/a", "u1")
+	deleted.UUID = "4b7c6a2e-0f0e-4c55-9d55-3f1c4a3e9b10"
+	deleted.IsDeleted = true
+	require.NoError(t, repo.Save(ctx, deleted))
+
+	batched := link("bbb", "https://example.com/b", "u2")
+	batched.IsDeleted = true
+	results, err := repo.BatchSave(ctx, []store.StoredURL{batched})
+	require.NoError(t, err)
+	require.Equal(t, store.BatchCreated, results[0].Status)
+
+	got, err := repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	sameURL(t, deleted, *got)
+	got, err = repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	sameURL(t, batched, *got)
+}
+
+func testNotFound(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	_, err := repo.FindByShortID(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = repo.FindByOriginalURL(ctx, "u1", "https://example.com/missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+// The default scope is global: another user's link for the same URL counts.
+func testSaveConflicts(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+
+	err := repo.Save(ctx, link("aaa", "https://example.com/b", "u2"))
+	assert.ErrorIs(t, err, store.ErrShortURLConflict)
+	err = repo.Save(ctx, link("bbb", "https://example.com/a", "u2"))
+	assert.ErrorIs(t, err, store.ErrUniqueViolation)
+
+	// Neither failed save may overwrite the original link.
+	got, err := repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	sameURL(t, link("aaa", "https://example.com/a", "u1"), *got)
+	_, err = repo.FindByShortID(ctx, "bbb")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testBatchSave(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+
+	results, err := repo.BatchSave(ctx, []store.StoredURL{
+		link("bbb", "https://example.com/b", "u2"),
+		link("ccc", "https://example.com/a", "u2"),
+		link("aaa", "https://example.com/c", "u2"),
+		link("ddd", "https://example.com/b", "u2"),
+		link("bbb", "https://example.com/e", "u2"),
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	assert.Equal(t, store.BatchCreated, results[0].Status)
+	assert.Equal(t, store.BatchExists, results[1].Status)
+	if assert.NotNil(t, results[1].Existing) {
+		assert.Equal(t, "aaa", results[1].Existing.ShortURL)
+	}
+	assert.Equal(t, store.BatchConflict, results[2].Status)
+	assert.Equal(t, store.BatchExists, results[3].Status, "duplicate original URL within the batch")
+	assert.Equal(t, store.BatchConflict, results[4].Status, "duplicate short ID within the batch")
+
+	got, err := repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	sameURL(t, link("bbb", "https://example.com/b", "u2"), *got)
+	for _, id := range []string{"ccc", "ddd"} {
+		_, err := repo.FindByShortID(ctx, id)
+		assert.ErrorIs(t, err, store.ErrNotFound, id)
+	}
+}
+
+func testDedupPerUser(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+	require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/a", "u2")), "other user, same URL")
+	err := repo.Save(ctx, link("ccc", "https://example.com/a", "u1"))
+	assert.ErrorIs(t, err, store.ErrUniqueViolation)
+
+	got, err := repo.FindByOriginalURL(ctx, "u2", "https://example.com/a")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", got.ShortURL)
+	_, err = repo.FindByOriginalURL(ctx, "u3", "https://example.com/a")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	results, err := repo.BatchSave(ctx, []store.StoredURL{
+		link("ddd", "https://example.com/a", "u1"),
+		link("eee", "https://example.com/a", "u3"),
+		link("fff", "https://example.com/a", "u3"),
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, store.BatchExists, results[0].Status)
+	if assert.NotNil(t, results[0].Existing) {
+		assert.Equal(t, "aaa", results[0].Existing.ShortURL)
+	}
+	assert.Equal(t, store.BatchCreated, results[1].Status)
+	assert.Equal(t, store.BatchExists, results[2].Status, "duplicate within the batch for the same user")
+
+	urls, err := repo.GetURLsByUserID(ctx, "u2")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	require.NoError(t, repo.MarkDeleted(ctx, "u2", []string{"bbb"}))
+	got, err = repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	assert.True(t, got.IsDeleted)
+}
+
+func testDedupNone(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+	require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/a", "u1")))
+	err := repo.Save(ctx, link("aaa", "https://example.com/b", "u1"))
+	assert.ErrorIs(t, err, store.ErrShortURLConflict)
+
+	_, err = repo.FindByOriginalURL(ctx, "u1", "https://example.com/a")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	results, err := repo.BatchSave(ctx, []store.StoredURL{
+		link("ccc", "https://example.com/a", "u1"),
+		link("ddd", "https://example.com/a", "u1"),
+		link("aaa", "https://example.com/c", "u1"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []store.BatchStatus{store.BatchCreated, store.BatchCreated, store.BatchConflict},
+		[]store.BatchStatus{results[0].Status, results[1].Status, results[2].Status})
+
+	urls, err := repo.GetURLsByUserID(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 4)
+}
+
+func testGetURLsByUserID(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+	require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/b", "u1")))
+	require.NoError(t, repo.Save(ctx, link("ccc", "https://example.com/c", "u2")))
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"bbb"}))
+
+	urls, err := repo.GetURLsByUserID(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	urls = byShortURL(urls)
+	sameURL(t, link("aaa", "https://example.com/a", "u1"), urls[0])
+	deleted := link("bbb", "https://example.com/b", "u1")
+	deleted.IsDeleted = true
+	sameURL(t, deleted, urls[1])
+
+	urls, err = repo.GetURLsByUserID(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func testMarkDeleted(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+	require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/b", "u2")))
+
+	// Only the owner's links are deleted; unknown IDs are ignored.
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"aaa", "bbb", "missing"}))
+
+	got, err := repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	assert.True(t, got.IsDeleted)
+	got, err = repo.FindByShortID(ctx, "bbb")
+	require.NoError(t, err)
+	assert.False(t, got.IsDeleted)
+
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", nil))
+}
+
+func testMarkDeletedBatch(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, link("aaa", "https://example.com/a", "u1")))
+	require.NoError(t, repo.Save(ctx, link("bbb", "https://example.com/b", "u2")))
+	require.NoError(t, repo.Save(ctx, link("ccc", "https://example.com/c", "u2")))
+
+	require.NoError(t, repo.MarkDeletedBatch(ctx, []store.DeleteRequest{
+		{UserID: "u1", ShortIDs: []string{"aaa"}},
+		{UserID: "u2", ShortIDs: []string{"bbb"}},
+		{UserID: "u1", ShortIDs: []string{"ccc"}},
+	}))
+
+	for id, deleted := range map[string]bool{"aaa": true, "bbb": true, "ccc": false} {
+		got, err := repo.FindByShortID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, deleted, got.IsDeleted, id)
+	}
+}
+
+func testExpireURLs(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	expired := link("aaa", "https://example.com/a", "u1")
+	expired.ExpiresAt = &past
+	live := link("bbb", "https://example.com/b", "u1")
+	live.ExpiresAt = &future
+	require.NoError(t, repo.Save(ctx, expired))
+	require.NoError(t, repo.Save(ctx, live))
+	require.NoError(t, repo.Save(ctx, link("ccc", "https://example.com/c", "u1")))
+
+	n, err := repo.ExpireURLs(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	got, err := repo.FindByShortID(ctx, "aaa")
+	require.NoError(t, err)
+	assert.True(t, got.IsDeleted)
+
+	n, err = repo.ExpireURLs(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n, "already expired links are not counted again")
+}
+
+func testLoad(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	urls, err := repo.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	a := link("aaa", "https://example.com/a", "u1")
+	a.ExpiresAt = &expires
+	b := link("bbb", "https://example.com/b", "u2")
+	require.NoError(t, repo.Save(ctx, a))
+	require.NoError(t, repo.Save(ctx, b))
+	require.NoError(t, repo.MarkDeleted(ctx, "u2", []string{"bbb"}))
+	b.IsDeleted = true
+
+	urls, err = repo.Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	urls = byShortURL(urls)
+	sameURL(t, a, urls[0])
+	sameURL(t, b, urls[1])
+}
+
+func testPing(t *testing.T, repo store.Repository) {
+	assert.NoError(t, repo.Ping(context.Background()))
+}
+
+func testAPIKeys(t *testing.T, ks store.APIKeyStore) {
+	ctx := context.Background()
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	expires := created.Add(48 * time.Hour)
+	first := store.APIKey{ID: "k1", UserID: "u1", Name: "ci", Hash: "h1", CreatedAt: created, ExpiresAt: &expires}
+	second := store.APIKey{ID: "k2", UserID: "u1", Name: "backup", Hash: "h2", CreatedAt: created.Add(time.Minute)}
+	require.NoError(t, ks.SaveAPIKey(ctx, first))
+	require.NoError(t, ks.SaveAPIKey(ctx, second))
+	require.NoError(t, ks.SaveAPIKey(ctx, store.APIKey{ID: "k3", UserID: "u2", Name: "other", Hash: "h3", CreatedAt: created}))
+
+	assert.ErrorIs(t, ks.SaveAPIKey(ctx, store.APIKey{ID: "k4", UserID: "u1", Name: "dup", Hash: "h1", CreatedAt: created}), store.ErrUniqueViolation)
+
+	got, err := ks.FindAPIKeyByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, "k1", got.ID)
+	assert.Equal(t, "u1", got.UserID)
+	assert.Equal(t, "ci", got.Name)
+	assert.True(t, created.Equal(got.CreatedAt))
+	require.NotNil(t, got.ExpiresAt)
+	assert.True(t, expires.Equal(*got.ExpiresAt))
+	assert.Nil(t, got.LastUsedAt)
+	assert.Nil(t, got.RevokedAt)
+
+	_, err = ks.FindAPIKeyByHash(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	keys, err := ks.GetAPIKeysByUserID(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "k1", keys[0].ID)
+	assert.Equal(t, "k2", keys[1].ID)
+	keys, err = ks.GetAPIKeysByUserID(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	used := created.Add(30 * time.Minute)
+	require.NoError(t, ks.TouchAPIKey(ctx, "k1", used))
+	assert.ErrorIs(t, ks.TouchAPIKey(ctx, "missing", used), store.ErrNotFound)
+
+	revoked := created.Add(40 * time.Minute)
+	assert.ErrorIs(t, ks.RevokeAPIKey(ctx, "u2", "k1", revoked), store.ErrNotFound, "only the owner may revoke")
+	require.NoError(t, ks.RevokeAPIKey(ctx, "u1", "k1", revoked))
+	require.NoError(t, ks.RevokeAPIKey(ctx, "u1", "k1", revoked.Add(time.Hour)))
+
+	got, err = ks.FindAPIKeyByHash(ctx, "h1")
+	require.NoError(t, err)
+	require.NotNil(t, got.LastUsedAt)
+	assert.True(t, used.Equal(*got.LastUsedAt))
+	require.NotNil(t, got.RevokedAt)
+	assert.True(t, revoked.Equal(*got.RevokedAt), "revoking again keeps the first time")
+	assert.False(t, got.Usable(time.Now()))
+}
+
+func testListURLsAfter(t *testing.T, repo store.Repository, pager store.URLPager) {
+	ctx := context.Background()
+	for _, id := range []string{"ccc", "aaa", "ddd", "bbb"} {
+		require.NoError(t, repo.Save(ctx, link(id, "https://example.com/"+id, "u1")))
+	}
+	require.NoError(t, repo.MarkDeleted(ctx, "u1", []string{"bbb"}))
+
+	ids := func(urls []store.StoredURL) []string {
+		var out []string
+		for _, u := range urls {
+			out = append(out, u.ShortURL)
+		}
+		return out
+	}
+
+	page, err := pager.ListURLsAfter(ctx, "", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaa", "bbb", "ccc"}, ids(page))
+	assert.True(t, page[1].IsDeleted, "deleted links are listed too")
+
+	page, err = pager.ListURLsAfter(ctx, "ccc", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ddd"}, ids(page))
+
+	page, err = pager.ListURLsAfter(ctx, "ddd", 3)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
@@ -0,0 +1,59 @@
+package store
+
+// Wrapper is implemented by repositories that decorate another one, such as
+// CachedRepository. They carry the methods of every optional capability, so
+// whether a capability is really there depends on what they wrap: use
+// AsClickStore, AsIDRangeLeaser, AsAPIKeyStore, AsURLPager and AsURLCounter
+// rather than a type assertion.
+type Wrapper interface {
+	Unwrap() Repository
+}
+
+// supports reports whether repo and everything it wraps implement T.
+func supports[T any](repo Repository) bool {
+	for {
+		if _, ok := repo.(T); !ok {
+			return false
+		}
+		w, ok := repo.(Wrapper)
+		if !ok {
+			return true
+		}
+		repo = w.Unwrap()
+	}
+}
+
+func AsClickStore(repo Repository) (ClickStore, bool) {
+	if !supports[ClickStore](repo) {
+		return nil, false
+	}
+	return repo.(ClickStore), true
+}
+
+func AsIDRangeLeaser(repo Repository) (IDRangeLeaser, bool) {
+	if !supports[IDRangeLeaser](repo) {
+		return nil, false
+	}
+	return repo.(IDRangeLeaser), true
+}
+
+func AsAPIKeyStore(repo Repository) (APIKeyStore, bool) {
+	if !supports[APIKeyStore](repo) {
+		return nil, false
+	}
+	return repo.(APIKeyStore), true
+}
+
+func AsURLPager(repo Repository) (URLPager, bool) {
+	if !supports[URLPager](repo) {
+		return nil, false
+	}
+	return repo.(URLPager), true
+}
+
+func AsURLCounter(repo Repository) (URLCounter, bool) {
+	if !supports[URLCounter](repo) {
+		return nil, false
+	}
+	return repo.(URLCounter), true
+}
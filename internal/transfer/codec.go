@@ -0,0 +1,150 @@
+package transfer
+
+import (
+	"bufio"
+	"cuturl/internal/store"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatJSONL Format = "jsonl"
+	FormatCSV   Format = "csv"
+)
+
+// csvHeader is the column order of CSV exports; ExpiresAt is RFC 3339 or
+// empty.
+var csvHeader = []string{"uuid", "short_url", "original_url", "user_id", "is_deleted", "expires_at"}
+
+// ParseFormat resolves an explicit format name, or guesses one from path's
+// extension when name is empty.
+func ParseFormat(name, path string) (Format, error) {
+	if name == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return FormatCSV, nil
+		}
+		return FormatJSONL, nil
+	}
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatJSONL, FormatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q: want jsonl or csv", name)
+}
+
+type Encoder interface {
+	Encode(u store.StoredURL) error
+	// Flush writes out anything buffered.
+	Flush() error
+}
+
+type Decoder interface {
+	// Decode returns the next record, or io.EOF after the last one.
+	Decode() (store.StoredURL, error)
+}
+
+// NewEncoder writes records in format to w. For CSV, header controls whether
+// the column row is written first; appending to an earlier export skips it.
+func NewEncoder(w io.Writer, format Format, header bool) Encoder {
+	if format == FormatCSV {
+		return &csvEncoder{w: csv.NewWriter(w), header: header}
+	}
+	bw := bufio.NewWriter(w)
+	return &jsonlEncoder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func NewDecoder(r io.Reader, format Format) Decoder {
+	if format == FormatCSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(csvHeader)
+		return &csvDecoder{r: cr}
+	}
+	return &jsonlDecoder{dec: json.NewDecoder(r)}
+}
+
+type jsonlEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) Encode(u store.StoredURL) error {
+	return e.enc.Encode(u)
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type jsonlDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonlDecoder) Decode() (store.StoredURL, error) {
+	var u store.StoredURL
+	err := d.dec.Decode(&u)
+	return u, err
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(u store.StoredURL) error {
+	if e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.header = false
+	}
+	expiresAt := ""
+	if u.ExpiresAt != nil {
+		expiresAt = u.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{u.UUID, u.ShortURL, u.OriginalURL, u.UserID, strconv.FormatBool(u.IsDeleted), expiresAt})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+}
+
+func (d *csvDecoder) Decode() (store.StoredURL, error) {
+	for {
+		row, err := d.r.Read()
+		if err != nil {
+			return store.StoredURL{}, err
+		}
+		if row[0] == csvHeader[0] && row[1] == csvHeader[1] {
+			continue
+		}
+
+		u := store.StoredURL{UUID: row[0], ShortURL: row[1], OriginalURL: row[2], UserID: row[3]}
+		line, _ := d.r.FieldPos(0)
+		if row[4] != "" {
+			if u.IsDeleted, err = strconv.ParseBool(row[4]); err != nil {
+				return store.StoredURL{}, fmt.Errorf("line %d: is_deleted: %w", line, err)
+			}
+		}
+		if row[5] != "" {
+			t, err := time.Parse(time.RFC3339Nano, row[5])
+			if err != nil {
+				return store.StoredURL{}, fmt.Errorf("line %d: expires_at: %w", line, err)
+			}
+			u.ExpiresAt = &t
+		}
+		return u, nil
+	}
+}
@@ -0,0 +1,268 @@
+// Package transfer copies links between repositories through a portable
+// JSON-lines or CSV file.
+package transfer
+
+import (
+	"context"
+	"cuturl/internal/store"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const DefaultBatchSize = 500
+
+// Export writes every link in repo to enc, ordered by short ID. Links up to
+// and including after are skipped, so an interrupted export can continue
+// from the last short ID it wrote. Repositories that can page through their
+// links are read a page at a time; others are loaded whole.
+func Export(ctx context.Context, repo store.Repository, enc Encoder, after string) (int, error) {
+	pager, ok := store.AsURLPager(repo)
+	if !ok {
+		pager = &loadedPager{repo: repo}
+	}
+
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		urls, err := pager.ListURLsAfter(ctx, after, DefaultBatchSize)
+		if err != nil {
+			return n, err
+		}
+		for _, u := range urls {
+			if err := enc.Encode(u); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(urls) < DefaultBatchSize {
+			return n, enc.Flush()
+		}
+		after = urls[len(urls)-1].ShortURL
+	}
+}
+
+// loadedPager pages through a repository that can only load everything at
+// once, loading it on the first call.
+type loadedPager struct {
+	repo   store.Repository
+	urls   []store.StoredURL
+	loaded bool
+}
+
+func (p *loadedPager) ListURLsAfter(ctx context.Context, after string, limit int) ([]store.StoredURL, error) {
+	if !p.loaded {
+		urls, err := p.repo.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(urls, func(i, j int) bool { return urls[i].ShortURL < urls[j].ShortURL })
+		p.urls, p.loaded = urls, true
+	}
+	i := sort.Search(len(p.urls), func(i int) bool { return p.urls[i].ShortURL > after })
+	return p.urls[i:min(i+limit, len(p.urls))], nil
+}
+
+// LastShortID returns the short ID of the last record dec produces, or ""
+// for an empty export.
+func LastShortID(dec Decoder) (string, error) {
+	last := ""
+	for {
+		u, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			return last, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		last = u.ShortURL
+	}
+}
+
+type ImportOptions struct {
+	BatchSize int
+	// Skip is the number of records a previous run already processed.
+	Skip int
+	// DryRun only reports what would happen; nothing is written.
+	DryRun bool
+	// Checkpoint, if set, is called with the number of records processed so
+	// far after each batch is committed.
+	Checkpoint func(processed int) error
+	// OnConflict, if set, is called for each record that was not imported
+	// because it clashes with a different link.
+	OnConflict func(u store.StoredURL, reason string)
+}
+
+type ImportStats struct {
+	Processed int
+	Created   int
+	// Existing counts records that are already present unchanged, e.g. from
+	// an earlier run.
+	Existing  int
+	Conflicts int
+}
+
+// Import reads records from dec into repo in batches. It is idempotent:
+// re-importing a record that is already present counts as Existing.
+func Import(ctx context.Context, repo store.Repository, dec Decoder, opts ImportOptions) (ImportStats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	stats := ImportStats{Processed: opts.Skip}
+
+	for skipped := 0; skipped < opts.Skip; skipped++ {
+		if _, err := dec.Decode(); err != nil {
+			return stats, fmt.Errorf("skipping %d already imported records: %w", opts.Skip, err)
+		}
+	}
+
+	batch := make([]store.StoredURL, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var err error
+		if opts.DryRun {
+			err = dryRun(ctx, repo, batch, &stats, opts.OnConflict)
+		} else {
+			err = importBatch(ctx, repo, batch, &stats, opts.OnConflict)
+		}
+		if err != nil {
+			return err
+		}
+		stats.Processed += len(batch)
+		batch = batch[:0]
+		if opts.Checkpoint != nil && !opts.DryRun {
+			return opts.Checkpoint(stats.Processed)
+		}
+		return nil
+	}
+
+	for {
+		u, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %w", stats.Processed+len(batch)+1, err)
+		}
+		if u.ShortURL == "" || u.OriginalURL == "" {
+			return stats, fmt.Errorf("record %d: short_url and original_url are required", stats.Processed+len(batch)+1)
+		}
+		if u.UUID == "" {
+			u.UUID = u.ShortURL
+		}
+		batch = append(batch, u)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, flush()
+}
+
+func importBatch(ctx context.Context, repo store.Repository, batch []store.StoredURL, stats *ImportStats, onConflict func(store.StoredURL, string)) error {
+	results, err := repo.BatchSave(ctx, batch)
+	if err != nil {
+		return err
+	}
+	for i, res := range results {
+		switch {
+		case res.Status == store.BatchCreated:
+			stats.Created++
+		case res.Status == store.BatchExists && sameShortID(*res.Existing, batch[i]):
+			stats.Existing++
+		case res.Status == store.BatchExists:
+			stats.Conflicts++
+			report(onConflict, batch[i], existsReason(*res.Existing, batch[i]))
+		default:
+			// Without deduplication a record imported earlier shows up as a
+			// short ID conflict with itself.
+			taken, err := repo.FindByShortID(ctx, batch[i].ShortURL)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+			if taken != nil && sameLink(*taken, batch[i]) {
+				stats.Existing++
+				continue
+			}
+			stats.Conflicts++
+			report(onConflict, batch[i], takenReason(taken, batch[i]))
+		}
+	}
+	return nil
+}
+
+// sameLink reports whether stored is the link u describes, deleted or not
+// alike. A record that differs only in its deletion state is a conflict:
+// importing it would neither restore nor delete the stored link.
+func sameLink(stored, u store.StoredURL) bool {
+	return sameShortID(stored, u) && stored.OriginalURL == u.OriginalURL && stored.UserID == u.UserID
+}
+
+// sameShortID is sameLink for a link already known to share u's original URL.
+func sameShortID(stored, u store.StoredURL) bool {
+	return stored.ShortURL == u.ShortURL && stored.IsDeleted == u.IsDeleted
+}
+
+const deletionMismatch = "link exists with a different deletion state"
+
+func existsReason(stored, u store.StoredURL) string {
+	if stored.ShortURL == u.ShortURL {
+		return deletionMismatch
+	}
+	return "original URL already shortened as " + stored.ShortURL
+}
+
+func takenReason(stored *store.StoredURL, u store.StoredURL) string {
+	if stored != nil && stored.OriginalURL == u.OriginalURL && stored.UserID == u.UserID {
+		return deletionMismatch
+	}
+	return "short ID belongs to another URL"
+}
+
+// dryRun classifies a batch with lookups only. Duplicates within the input
+// itself are not detected.
+func dryRun(ctx context.Context, repo store.Repository, batch []store.StoredURL, stats *ImportStats, onConflict func(store.StoredURL, string)) error {
+	for _, u := range batch {
+		byOriginal, err := repo.FindByOriginalURL(ctx, u.UserID, u.OriginalURL)
+		switch {
+		case err == nil && sameShortID(*byOriginal, u):
+			stats.Existing++
+			continue
+		case err == nil:
+			stats.Conflicts++
+			report(onConflict, u, existsReason(*byOriginal, u))
+			continue
+		case !errors.Is(err, store.ErrNotFound):
+			return err
+		}
+
+		taken, err := repo.FindByShortID(ctx, u.ShortURL)
+		switch {
+		case err == nil && sameLink(*taken, u):
+			stats.Existing++
+		case err == nil:
+			stats.Conflicts++
+			report(onConflict, u, takenReason(taken, u))
+		case errors.Is(err, store.ErrNotFound):
+			stats.Created++
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func report(onConflict func(store.StoredURL, string), u store.StoredURL, reason string) {
+	if onConflict != nil {
+		onConflict(u, reason)
+	}
+}
@@ -0,0 +1,243 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"cuturl/internal/store"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seed(t *testing.T, repo store.Repository) []store.StoredURL {
+	t.Helper()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	urls := []store.StoredURL{
+		{UUID: "1", ShortURL: "aaa", OriginalURL: "https://example.com/a", UserID: "u1", ExpiresAt: &expires},
+		{UUID: "2", ShortURL: "bbb", OriginalURL: "https://example.com/b?x=1,2", UserID: "u2", IsDeleted: true},
+		{UUID: "3", ShortURL: "ccc", OriginalURL: `https://example.com/"c"`},
+	}
+	for _, u := range urls {
+		require.NoError(t, repo.Save(context.Background(), u))
+	}
+	return urls
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatJSONL, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			src := store.NewInMemoryRepository()
+			want := seed(t, src)
+
+			var buf bytes.Buffer
+			n, err := Export(ctx, src, NewEncoder(&buf, format, true), "")
+			require.NoError(t, err)
+			assert.Equal(t, 3, n)
+
+			dst := store.NewFileRepository(filepath.Join(t.TempDir(), "urls.json"))
+			stats, err := Import(ctx, dst, NewDecoder(&buf, format), ImportOptions{BatchSize: 2})
+			require.NoError(t, err)
+			assert.Equal(t, ImportStats{Processed: 3, Created: 3}, stats)
+
+			for _, w := range want {
+				got, err := dst.FindByShortID(ctx, w.ShortURL)
+				require.NoError(t, err)
+				assert.Equal(t, w.UUID, got.UUID)
+				assert.Equal(t, w.OriginalURL, got.OriginalURL)
+				assert.Equal(t, w.UserID, got.UserID)
+				assert.Equal(t, w.IsDeleted, got.IsDeleted)
+				if w.ExpiresAt == nil {
+					assert.Nil(t, got.ExpiresAt)
+				} else if assert.NotNil(t, got.ExpiresAt) {
+					assert.True(t, w.ExpiresAt.Equal(*got.ExpiresAt))
+				}
+			}
+		})
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewInMemoryRepository()
+	seed(t, src)
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, NewEncoder(&buf, FormatCSV, true), "")
+	require.NoError(t, err)
+	full := buf.String()
+
+	// Keep the header and the first record, as if the export had stopped there.
+	lines := strings.SplitAfter(full, "\n")
+	partial := lines[0] + lines[1]
+	after, err := LastShortID(NewDecoder(strings.NewReader(partial), FormatCSV))
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", after)
+
+	resumed := bytes.NewBufferString(partial)
+	n, err := Export(ctx, src, NewEncoder(resumed, FormatCSV, false), after)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, full, resumed.String())
+}
+
+func TestExportPagesThroughSQLite(t *testing.T) {
+	ctx := context.Background()
+	src, err := store.NewSQLiteRepository(ctx, filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	defer src.Close()
+
+	const total = 2*DefaultBatchSize + 1
+	urls := make([]store.StoredURL, total)
+	for i := range urls {
+		id := fmt.Sprintf("id%05d", i)
+		urls[i] = store.StoredURL{UUID: id, ShortURL: id, OriginalURL: "https://example.com/" + id}
+	}
+	_, err = src.BatchSave(ctx, urls)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, src, NewEncoder(&buf, FormatJSONL, true), "id00099")
+	require.NoError(t, err)
+	assert.Equal(t, total-100, n)
+	last, err := LastShortID(NewDecoder(&buf, FormatJSONL))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("id%05d", total-1), last)
+}
+
+func TestImportResumeAndConflicts(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewInMemoryRepository()
+	seed(t, src)
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, NewEncoder(&buf, FormatJSONL, true), "")
+	require.NoError(t, err)
+	data := buf.String()
+
+	dst := store.NewInMemoryRepository()
+	require.NoError(t, dst.Save(ctx, store.StoredURL{UUID: "x", ShortURL: "ccc", OriginalURL: "https://example.com/other"}))
+
+	// A previous run imported the first record and checkpointed it.
+	var checkpoints []int
+	stats, err := Import(ctx, dst, NewDecoder(strings.NewReader(data), FormatJSONL), ImportOptions{
+		BatchSize:  1,
+		Skip:       1,
+		Checkpoint: func(processed int) error { checkpoints = append(checkpoints, processed); return nil },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Processed: 3, Created: 1, Conflicts: 1}, stats)
+	assert.Equal(t, []int{2, 3}, checkpoints)
+	_, err = dst.FindByShortID(ctx, "aaa")
+	assert.ErrorIs(t, err, store.ErrNotFound, "skipped record")
+
+	// Running again from the start is harmless.
+	var conflicts []string
+	stats, err = Import(ctx, dst, NewDecoder(strings.NewReader(data), FormatJSONL), ImportOptions{
+		OnConflict: func(u store.StoredURL, _ string) { conflicts = append(conflicts, u.ShortURL) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Processed: 3, Created: 1, Existing: 1, Conflicts: 1}, stats)
+	assert.Equal(t, []string{"ccc"}, conflicts)
+}
+
+func TestImportDryRun(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewInMemoryRepository()
+	seed(t, src)
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, NewEncoder(&buf, FormatJSONL, true), "")
+	require.NoError(t, err)
+
+	dst := store.NewInMemoryRepository()
+	require.NoError(t, dst.Save(ctx, store.StoredURL{UUID: "1", ShortURL: "aaa", OriginalURL: "https://example.com/a"}))
+	require.NoError(t, dst.Save(ctx, store.StoredURL{UUID: "x", ShortURL: "zzz", OriginalURL: "https://example.com/b?x=1,2"}))
+
+	stats, err := Import(ctx, dst, NewDecoder(&buf, FormatJSONL), ImportOptions{
+		DryRun:     true,
+		Checkpoint: func(int) error { t.Fatal("dry run must not checkpoint"); return nil },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Processed: 3, Created: 1, Existing: 1, Conflicts: 1}, stats)
+
+	urls, err := dst.Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name, path string
+		want       Format
+		wantErr    bool
+	}{
+		{"", "out.csv", FormatCSV, false},
+		{"", "out.jsonl", FormatJSONL, false},
+		{"", "-", FormatJSONL, false},
+		{"CSV", "out.jsonl", FormatCSV, false},
+		{"xml", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.name, tt.path)
+		if tt.wantErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestImportTwiceWithoutDedup(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewInMemoryRepository()
+	seed(t, src)
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, NewEncoder(&buf, FormatJSONL, true), "")
+	require.NoError(t, err)
+	data := buf.String()
+
+	dst := store.NewInMemoryRepository(store.WithDedupScope(store.DedupNone))
+	_, err = Import(ctx, dst, NewDecoder(strings.NewReader(data), FormatJSONL), ImportOptions{})
+	require.NoError(t, err)
+	stats, err := Import(ctx, dst, NewDecoder(strings.NewReader(data), FormatJSONL), ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Processed: 3, Existing: 3}, stats)
+}
+
+func TestImportDeletionStateMismatch(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewInMemoryRepository()
+	seed(t, src)
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, NewEncoder(&buf, FormatJSONL, true), "")
+	require.NoError(t, err)
+	data := buf.String()
+
+	for _, scope := range []store.DedupScope{store.DedupGlobal, store.DedupNone} {
+		t.Run(string(scope), func(t *testing.T) {
+			// "bbb" is deleted in the export but live here.
+			dst := store.NewInMemoryRepository(store.WithDedupScope(scope))
+			require.NoError(t, dst.Save(ctx, store.StoredURL{UUID: "2", ShortURL: "bbb", OriginalURL: "https://example.com/b?x=1,2", UserID: "u2"}))
+
+			for _, dry := range []bool{true, false} {
+				var reasons []string
+				stats, err := Import(ctx, dst, NewDecoder(strings.NewReader(data), FormatJSONL), ImportOptions{
+					DryRun:     dry,
+					OnConflict: func(_ store.StoredURL, reason string) { reasons = append(reasons, reason) },
+				})
+				require.NoError(t, err)
+				assert.Equal(t, ImportStats{Processed: 3, Created: 2, Conflicts: 1}, stats, "dry run: %v", dry)
+				assert.Equal(t, []string{deletionMismatch}, reasons)
+			}
+
+			entry, err := dst.FindByShortID(ctx, "bbb")
+			require.NoError(t, err)
+			assert.False(t, entry.IsDeleted)
+		})
+	}
+}